@@ -0,0 +1,28 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// inheritedListeners はソケットアクティベーションに対応していない環境では何も返さない
+func inheritedListeners() (map[string]net.Listener, bool, error) {
+	return map[string]net.Listener{}, false, nil
+}
+
+func notifyReady() {}
+
+// upgradeSignal はアップグレードに対応していない環境では何も受け取らないチャネルを返す
+func upgradeSignal() <-chan os.Signal {
+	return nil
+}
+
+func upgrade(addrs []string, files []*os.File) error {
+	for _, f := range files {
+		f.Close()
+	}
+	return fmt.Errorf("upgrade is not supported on this platform")
+}
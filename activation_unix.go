@@ -0,0 +1,225 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// systemd から渡されるファイルディスクリプタの先頭の番号
+	listenFdsStart = 3
+
+	// アップグレード時に新しいプロセスに待ち受けを渡すための環境変数
+	envListenFds   = "BRAGI_LISTEN_FDS"
+	envListenAddrs = "BRAGI_LISTEN_ADDRS"
+	envReadyFd     = "BRAGI_READY_FD"
+
+	// 新しいプロセスの準備ができるまで待つ時間
+	upgradeTimeout = 30 * time.Second
+)
+
+// fileListeners はファイルディスクリプタ listenFdsStart から n 個の待ち受けを作る
+func fileListeners(n int, names []string) (map[string]net.Listener, error) {
+	ls := map[string]net.Listener{}
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+
+		f := os.NewFile(uintptr(fd), "listener-"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "fd %d", fd)
+		}
+
+		addr := listenerAddr(l)
+		if i < len(names) && names[i] != "" && names[i] != "unknown" {
+			addr = names[i]
+		}
+		ls[addr] = l
+	}
+	return ls, nil
+}
+
+// inheritedListeners は systemd のソケットアクティベーションか、アップグレード前のプロセスから渡された待ち受けを返す。
+// systemd から渡された場合は true を返す
+func inheritedListeners() (map[string]net.Listener, bool, error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil {
+			return nil, false, errors.Wrap(err, "invalid LISTEN_FDS")
+		}
+		var names []string
+		if v := os.Getenv("LISTEN_FDNAMES"); v != "" {
+			names = strings.Split(v, ":")
+		}
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+
+		ls, err := fileListeners(n, names)
+		return ls, true, err
+	}
+
+	if v := os.Getenv(envListenFds); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "invalid %s", envListenFds)
+		}
+		names, err := parseListenAddrs(os.Getenv(envListenAddrs))
+		if err != nil {
+			return nil, false, errors.WithStack(err)
+		}
+		os.Unsetenv(envListenFds)
+		os.Unsetenv(envListenAddrs)
+
+		ls, err := fileListeners(n, names)
+		return ls, false, err
+	}
+
+	return map[string]net.Listener{}, false, nil
+}
+
+// formatListenAddrs は新しいプロセスに渡す待ち受けのアドレスを JSON にする。
+// UNIX ドメインソケットのパスには区切り文字に使える文字がないため、区切らずに配列のまま渡す
+func formatListenAddrs(addrs []string) (string, error) {
+	b, err := json.Marshal(addrs)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return string(b), nil
+}
+
+// parseListenAddrs は formatListenAddrs で渡された待ち受けのアドレスを返す
+func parseListenAddrs(v string) ([]string, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var addrs []string
+	if err := json.Unmarshal([]byte(v), &addrs); err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", envListenAddrs)
+	}
+	return addrs, nil
+}
+
+// sdNotify は systemd に状態を知らせる。systemd で動かしていない場合は何もしない
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// @ で始まる場合は抽象名前空間のソケット
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return errors.WithStack(err)
+}
+
+// notifyReady は待ち受けの準備ができたことを systemd か、アップグレード前のプロセスに知らせる
+func notifyReady() {
+	v := os.Getenv(envReadyFd)
+	if v == "" {
+		if err := sdNotify("READY=1"); err != nil {
+			slog.Warn("failed to notify systemd", "err", err)
+		}
+		return
+	}
+	// systemd にはアップグレード前のプロセスが MAINPID で知らせる
+	os.Unsetenv(envReadyFd)
+
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("invalid ready fd", "env", envReadyFd, "value", v)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		slog.Warn("failed to notify ready", "err", err)
+	}
+}
+
+// upgradeSignal はアップグレードを指示するシグナル(SIGUSR2)を受け取るチャネルを返す
+func upgradeSignal() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+	return c
+}
+
+// upgrade は同じ実行ファイルと引数で新しいプロセスを起動して待ち受けのファイルを渡し、準備ができるまで待つ。
+// systemd で動かしている場合は新しいプロセスをサービスのメインプロセスとして知らせる
+func upgrade(addrs []string, files []*os.File) error {
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	addrsEnv, err := formatListenAddrs(addrs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%d", envListenFds, len(files)),
+		fmt.Sprintf("%s=%s", envListenAddrs, addrsEnv),
+		fmt.Sprintf("%s=%d", envReadyFd, listenFdsStart+len(files)),
+	)
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return errors.WithStack(err)
+	}
+	w.Close()
+	slog.Info("Started new process, waiting for it to be ready...", "pid", cmd.Process.Pid)
+
+	// 新しいプロセスが終了した場合は書き込み側が閉じられて EOF になる
+	r.SetReadDeadline(time.Now().Add(upgradeTimeout))
+	buf := make([]byte, 1)
+	if _, err := r.Read(buf); err != nil {
+		cmd.Process.Kill()
+		return errors.Wrap(err, "new process did not become ready")
+	}
+	go cmd.Wait()
+
+	// 知らせないとこのプロセスの終了でサービスが止まったとみなされる
+	if err := sdNotify(fmt.Sprintf("MAINPID=%d", cmd.Process.Pid)); err != nil {
+		slog.Warn("failed to notify systemd of the new main process", "pid", cmd.Process.Pid, "err", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// querySKK は SKK サーバーに見出し語を問い合わせて応答を返す
+func querySKK(addr, text string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("1" + text + " ")); err != nil {
+		return "", err
+	}
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestListenAddrs(t *testing.T) {
+	addrs := []string{"127.0.0.1:1178", "/run/bragi/a,b.sock", "", "@abstract:name"}
+	v, err := formatListenAddrs(addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseListenAddrs(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, addrs) {
+		t.Errorf("parseListenAddrs(%q) = %q, want %q", v, got, addrs)
+	}
+
+	if got, err := parseListenAddrs(""); err != nil || got != nil {
+		t.Errorf("parseListenAddrs(\"\") = %q, %v", got, err)
+	}
+	if _, err := parseListenAddrs("127.0.0.1:1178,/run/bragi.sock"); err == nil {
+		t.Error("comma separated addresses are accepted")
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	cpath := writeTestConfig(t, fmt.Sprintf("listen = [%q]\n", addr))
+
+	// systemd の代わりに NOTIFY_SOCKET で通知を受け取る
+	notifyPath := filepath.Join(t.TempDir(), "notify.sock")
+	notify, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: notifyPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notify.Close()
+	states := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := notify.Read(buf)
+			if err != nil {
+				return
+			}
+			states <- string(buf[:n])
+		}
+	}()
+
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// アップグレード後のプロセスと同じように待ち受けを引き継いで起動する
+	addrsEnv, err := formatListenAddrs([]string{addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-c", cpath, "run")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(),
+		envTestMain+"=1",
+		"NOTIFY_SOCKET="+notifyPath,
+		envListenFds+"=1",
+		envListenAddrs+"="+addrsEnv,
+	)
+	if testing.Verbose() {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	var mainPid atomic.Int64
+	mainPid.Store(int64(cmd.Process.Pid))
+	t.Cleanup(func() {
+		syscall.Kill(int(mainPid.Load()), syscall.SIGKILL)
+		cmd.Process.Kill()
+	})
+
+	waitState := func(prefix string) string {
+		t.Helper()
+		timeout := time.After(30 * time.Second)
+		for {
+			select {
+			case st := <-states:
+				if strings.HasPrefix(st, prefix) {
+					return st
+				}
+			case <-timeout:
+				t.Fatalf("%s is not notified", prefix)
+			}
+		}
+	}
+	waitState("READY=1")
+
+	want := "1/試験;/\n"
+	if res, err := querySKK(addr, "てすと"); err != nil || res != want {
+		t.Fatalf("before upgrade: %q, %v", res, err)
+	}
+
+	// アップグレード中も接続を受け付け続けることを確かめる
+	var wg sync.WaitGroup
+	var failures atomic.Int64
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if res, err := querySKK(addr, "てすと"); err != nil || res != want {
+				t.Logf("query failed during upgrade: %q, %v", res, err)
+				failures.Add(1)
+			}
+		}
+	}()
+
+	if err := cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	st := waitState("MAINPID=")
+	pid, err := strconv.Atoi(strings.TrimPrefix(st, "MAINPID="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainPid.Store(int64(pid))
+	if pid == cmd.Process.Pid {
+		t.Fatalf("MAINPID is the old process: %d", pid)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(30 * time.Second):
+		t.Fatal("old process did not exit")
+	}
+	close(stop)
+	wg.Wait()
+	if n := failures.Load(); n > 0 {
+		t.Errorf("%d queries failed during upgrade", n)
+	}
+
+	if res, err := querySKK(addr, "てすと"); err != nil || res != want {
+		t.Fatalf("after upgrade: %q, %v", res, err)
+	}
+
+	// 前のプロセスがロックを解放した後に新しいプロセスが pid ファイルを書き込む
+	pidPath := filepath.Join(filepath.Dir(cpath), "bragi.pid")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		running, err := runningPid(pidPath)
+		if err == nil && running == pid {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pid file after upgrade: %d, %v, want %d", running, err, pid)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+}
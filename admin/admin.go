@@ -1,15 +1,19 @@
 package admin
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
 	"io/fs"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"sync"
 
 	"github.com/BurntSushi/toml"
 	"github.com/kan/bragi/config"
+	"github.com/kan/bragi/server"
 	"github.com/pkg/errors"
 )
 
@@ -20,6 +24,31 @@ type AdminServer struct {
 	Config      *config.Config
 	ConfigPath  string
 	RestartChan chan<- struct{}
+	// Server は実行中の SKK サーバーを返す。起動前は nil を返す
+	Server func() *server.Server
+	// Status は SKK サーバーや管理画面などの処理の状態を返す
+	Status func() []server.ComponentStatus
+	// Listener は管理画面の待ち受け。nil の場合は AdminPort で待ち受ける
+	Listener net.Listener
+
+	mu     sync.Mutex
+	hs     *http.Server
+	closed bool
+}
+
+func (a *AdminServer) current() *server.Server {
+	if a.Server == nil {
+		return nil
+	}
+	return a.Server()
+}
+
+// config は実行中の SKK サーバーの設定を返す。再読み込みした場合は再読み込み後の設定になる
+func (a *AdminServer) config() *config.Config {
+	if s := a.current(); s != nil {
+		return s.Config()
+	}
+	return a.Config
 }
 
 func (a *AdminServer) saveConfig(conf *config.Config) error {
@@ -41,7 +70,9 @@ func (a *AdminServer) Serve() error {
 		return errors.WithStack(err)
 	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	// 再起動したときに同じパターンを登録し直せるように、サーバーごとに ServeMux を作る
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" {
 			http.ServeFileFS(w, r, subfs, "index.html")
 			return
@@ -50,12 +81,12 @@ func (a *AdminServer) Serve() error {
 		http.FileServerFS(subfs).ServeHTTP(w, r)
 	})
 
-	http.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		switch r.Method {
 		case http.MethodGet:
-			if err := json.NewEncoder(w).Encode(a.Config); err != nil {
+			if err := json.NewEncoder(w).Encode(a.config()); err != nil {
 				http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
 				return
 			}
@@ -63,11 +94,18 @@ func (a *AdminServer) Serve() error {
 		case http.MethodPost:
 			var conf config.Config
 			if err := json.NewDecoder(r.Body).Decode(&conf); err != nil {
-				log.Printf("%+v", err)
+				slog.Warn("invalid config", "err", err)
 				http.Error(w, "Error decoding JSON", http.StatusBadRequest)
 				return
 			}
 
+			// 誤った設定をファイルに書き込むと次の起動に失敗するため、先に確かめる
+			if err := server.ValidateConfig(&conf); err != nil {
+				slog.Warn("invalid config", "err", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
 			if err := a.saveConfig(&conf); err != nil {
 				http.Error(w, "Error save config", http.StatusInternalServerError)
 				return
@@ -75,13 +113,13 @@ func (a *AdminServer) Serve() error {
 
 			select {
 			case a.RestartChan <- struct{}{}:
-				log.Println("Sent restart signal to SKK server")
+				slog.Info("Sent restart signal to SKK server")
 			default:
-				log.Println("Restart signal already sent")
+				slog.Info("Restart signal already sent")
 			}
 
 			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(a.Config); err != nil {
+			if err := json.NewEncoder(w).Encode(a.config()); err != nil {
 				http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
 				return
 			}
@@ -91,15 +129,162 @@ func (a *AdminServer) Serve() error {
 		}
 	})
 
-	server := &http.Server{Addr: ":" + a.Config.AdminPort}
-	log.Printf("Starting web server on port %s...", a.Config.AdminPort)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	mux.HandleFunc("/api/dicts", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sts := []server.DictStatus{}
+		if s := a.current(); s != nil {
+			sts = s.DictStatus()
+		}
+		if err := json.NewEncoder(w).Encode(sts); err != nil {
+			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+			return
+		}
+	})
+
+	mux.HandleFunc("/api/reloads", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		evs := []server.ReloadEvent{}
+		if s := a.current(); s != nil {
+			evs = s.ReloadEvents()
+		}
+		if err := json.NewEncoder(w).Encode(evs); err != nil {
+			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+			return
+		}
+	})
+
+	mux.HandleFunc("/api/connections", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		st := server.ConnStats{Rejected: map[string]uint64{}}
+		if s := a.current(); s != nil {
+			st = s.ConnStats()
+		}
+		if err := json.NewEncoder(w).Encode(st); err != nil {
+			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+			return
+		}
+	})
+
+	mux.HandleFunc("/api/lookup", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query().Get("q")
+		if q == "" {
+			http.Error(w, "q is required", http.StatusBadRequest)
+			return
+		}
+
+		s := a.current()
+		if s == nil {
+			http.Error(w, "SKK server is not running", http.StatusServiceUnavailable)
+			return
+		}
+		// SKK クライアントの接続制限を受けずに料金のかかる AI 辞書を使えないように、ai=true を指定した場合だけ使う
+		if err := json.NewEncoder(w).Encode(s.Lookup(q, r.URL.Query().Get("ai") == "true")); err != nil {
+			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+			return
+		}
+	})
+
+	mux.HandleFunc("/api/reverse", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query().Get("q")
+		if q == "" {
+			http.Error(w, "q is required", http.StatusBadRequest)
+			return
+		}
+
+		rs := []server.ReverseResult{}
+		if s := a.current(); s != nil {
+			rs = s.Reverse(q)
+		}
+		if err := json.NewEncoder(w).Encode(rs); err != nil {
+			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+			return
+		}
+	})
+
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sts := []server.ComponentStatus{}
+		if a.Status != nil {
+			sts = a.Status()
+		}
+		if err := json.NewEncoder(w).Encode(sts); err != nil {
+			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+			return
+		}
+	})
+
+	hs := &http.Server{Addr: ":" + a.Config.AdminPort, Handler: mux}
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.hs = hs
+	a.mu.Unlock()
+
+	if a.Listener != nil {
+		slog.Info("Starting web server", "addr", a.Listener.Addr().String())
+		err = hs.Serve(a.Listener)
+	} else {
+		slog.Info("Starting web server", "port", a.Config.AdminPort)
+		err = hs.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		return errors.WithStack(err)
 	}
 
 	return nil
 }
 
-func LoadServer(conf *config.Config, path string, c chan<- struct{}) *AdminServer {
-	return &AdminServer{Config: conf, ConfigPath: path, RestartChan: c}
+// Shutdown は新しいリクエストの受け付けを止め、処理中のリクエストが終わるまで待つ
+func (a *AdminServer) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	a.closed = true
+	hs := a.hs
+	a.mu.Unlock()
+
+	if hs == nil {
+		return nil
+	}
+	return errors.WithStack(hs.Shutdown(ctx))
+}
+
+func LoadServer(conf *config.Config, path string, c chan<- struct{}, current func() *server.Server) *AdminServer {
+	return &AdminServer{Config: conf, ConfigPath: path, RestartChan: c, Server: current}
 }
@@ -0,0 +1,158 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kan/bragi/config"
+	"github.com/kan/bragi/server"
+)
+
+// startAdmin は小さな辞書を使う SKK サーバーと管理画面を起動し、管理画面の URL を返す
+func startAdmin(t *testing.T) (*AdminServer, chan struct{}, string) {
+	t.Helper()
+	dir := t.TempDir()
+	dic := filepath.Join(dir, "test.dic")
+	if err := os.WriteFile(dic, []byte(";; okuri-nasi entries.\nしけん /試験/\nてすと /試験/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.LoadServer(&config.Config{
+		Dictionary: []string{dic},
+		DictPath:   dir,
+		DictOrder:  []string{config.DictSkk},
+		ServerName: "running",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 起動時の設定は再読み込み後の設定と異なる
+	restart := make(chan struct{}, 1)
+	a := LoadServer(&config.Config{ServerName: "startup"}, filepath.Join(dir, "config.toml"), restart, func() *server.Server { return s })
+	a.Listener = l
+	go a.Serve()
+	t.Cleanup(func() { a.Shutdown(context.Background()) })
+
+	return a, restart, "http://" + l.Addr().String()
+}
+
+func postConfig(t *testing.T, url string, conf *config.Config) *http.Response {
+	t.Helper()
+	b, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(url+"/api/config", "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestGetConfig(t *testing.T) {
+	_, _, url := startAdmin(t)
+
+	res, err := http.Get(url + "/api/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var conf config.Config
+	if err := json.NewDecoder(res.Body).Decode(&conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.ServerName != "running" {
+		t.Errorf("server_name = %q, want the running config", conf.ServerName)
+	}
+}
+
+func TestPostConfigInvalid(t *testing.T) {
+	a, restart, url := startAdmin(t)
+
+	for _, conf := range []*config.Config{
+		{Allow: []string{"192.168.0.0/99"}},
+		{Deny: []string{"not an address"}},
+		{SocketMode: "999"},
+		{LogLevel: "verbose"},
+	} {
+		if res := postConfig(t, url, conf); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("POST %+v: status = %d, want 400", conf, res.StatusCode)
+		}
+	}
+	if _, err := os.Stat(a.ConfigPath); !os.IsNotExist(err) {
+		t.Errorf("invalid config is written: %v", err)
+	}
+	select {
+	case <-restart:
+		t.Error("restart is requested for invalid config")
+	default:
+	}
+}
+
+func TestPostConfig(t *testing.T) {
+	a, restart, url := startAdmin(t)
+
+	if res := postConfig(t, url, &config.Config{Allow: []string{"127.0.0.1", "192.168.0.0/16"}}); res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", res.StatusCode)
+	}
+	if _, err := os.Stat(a.ConfigPath); err != nil {
+		t.Errorf("config is not written: %v", err)
+	}
+	select {
+	case <-restart:
+	default:
+		t.Error("restart is not requested")
+	}
+}
+
+func TestReverse(t *testing.T) {
+	_, _, url := startAdmin(t)
+
+	res, err := http.Get(url + "/api/reverse?q=" + neturl.QueryEscape("試験"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var rs []server.ReverseResult
+	if err := json.NewDecoder(res.Body).Decode(&rs); err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || !reflect.DeepEqual(rs[0].Labels, []string{"しけん", "てすと"}) {
+		t.Errorf("reverse = %+v, want labels [しけん てすと]", rs)
+	}
+
+	res, err = http.Get(url + "/api/reverse?q=" + neturl.QueryEscape("無い"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	rs = nil
+	if err := json.NewDecoder(res.Body).Decode(&rs); err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 0 {
+		t.Errorf("reverse = %+v, want no results", rs)
+	}
+
+	res, err = http.Get(url + "/api/reverse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d without q, want 400", res.StatusCode)
+	}
+}
@@ -1,10 +1,14 @@
 package config
 
 import (
-	"log"
+	"encoding/json"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/toml"
@@ -18,6 +22,11 @@ type Config struct {
 	AdminPort      string   `koanf:"admin_port" toml:"admin_port" json:"admin_port"`
 	UseAI          bool     `koanf:"use_ai" toml:"use_ai" json:"use_ai"`
 	UseLisp        bool     `koanf:"use_lisp" toml:"use_lisp" json:"use_lisp"`
+	UseEmoji       bool     `koanf:"use_emoji" toml:"use_emoji" json:"use_emoji"`
+	UseCalc        bool     `koanf:"use_calc" toml:"use_calc" json:"use_calc"`
+	UseUnit        bool     `koanf:"use_unit" toml:"use_unit" json:"use_unit"`
+	UseNumber      bool     `koanf:"use_number" toml:"use_number" json:"use_number"`
+	UseAbbrev      bool     `koanf:"use_abbrev" toml:"use_abbrev" json:"use_abbrev"`
 	YearFormat     string   `koanf:"year_format" toml:"year_format" json:"year_format"`
 	MonthFormat    string   `koanf:"month_format" toml:"month_format" json:"month_format"`
 	DateFormat     string   `koanf:"date_format" toml:"date_format" json:"date_format"`
@@ -25,6 +34,145 @@ type Config struct {
 	TimeZone       string   `koanf:"time_zone" toml:"time_zone" json:"time_zone"`
 	Dictionary     []string `koanf:"dictionary" toml:"dictionary" json:"dictionary"`
 	DictPath       string   `koanf:"dict_path" toml:"dict_path" json:"dict_path"`
+	DictOrder      []string `koanf:"dict_order" toml:"dict_order" json:"dict_order"`
+	CompactIndex   bool     `koanf:"compact_index" toml:"compact_index" json:"compact_index"`
+	LenientLoad    bool     `koanf:"lenient_load" toml:"lenient_load" json:"lenient_load"`
+	// Listen は SKK サーバーの待ち受けアドレス。「unix:パス」で Unix ドメインソケットを指定する。
+	// 空の場合は全てのインターフェースの Port で待ち受ける
+	Listen []string `koanf:"listen" toml:"listen" json:"listen"`
+	// SocketMode は Unix ドメインソケットのパーミッション(8進数)
+	SocketMode string `koanf:"socket_mode" toml:"socket_mode" json:"socket_mode"`
+	// Allow は SKK サーバーへの接続を許可するアドレス(CIDR)。空の場合は Deny 以外の全てを許可する
+	Allow []string `koanf:"allow" toml:"allow" json:"allow"`
+	// Deny は SKK サーバーへの接続を拒否するアドレス(CIDR)。Allow より優先する
+	Deny []string `koanf:"deny" toml:"deny" json:"deny"`
+	// MaxConns は SKK サーバーの同時接続数の上限。0 の場合は制限しない
+	MaxConns int `koanf:"max_conns" toml:"max_conns" json:"max_conns"`
+	// MaxConnsPerIP は接続元のアドレスごとの同時接続数の上限。0 の場合は制限しない
+	MaxConnsPerIP int `koanf:"max_conns_per_ip" toml:"max_conns_per_ip" json:"max_conns_per_ip"`
+	// PidFile は実行中のプロセス ID を書き込むファイル。空の場合はキャッシュディレクトリの bragi.pid を使う
+	PidFile string `koanf:"pid_file" toml:"pid_file" json:"pid_file"`
+	// ServerName は SKK サーバーのバージョンの問い合わせに返すサーバー名
+	ServerName string `koanf:"server_name" toml:"server_name" json:"server_name"`
+	// ServerHost はホスト名の問い合わせに返すホスト名。空の場合は OS のホスト名を返す
+	ServerHost string `koanf:"server_host" toml:"server_host" json:"server_host"`
+	// IdleTimeout は SKK サーバーで次のリクエストを待つ時間(秒)。0 の場合は制限しない
+	IdleTimeout int `koanf:"idle_timeout" toml:"idle_timeout" json:"idle_timeout"`
+	// ReadTimeout はリクエストを読み終えるまでの時間(秒)。0 の場合は制限しない
+	ReadTimeout int `koanf:"read_timeout" toml:"read_timeout" json:"read_timeout"`
+	// WriteTimeout はレスポンスを書き終えるまでの時間(秒)。0 の場合は制限しない
+	WriteTimeout int `koanf:"write_timeout" toml:"write_timeout" json:"write_timeout"`
+	// ShutdownTimeout は終了時に処理中のリクエストが終わるのを待つ時間(秒)。経過後は接続を閉じる
+	ShutdownTimeout int `koanf:"shutdown_timeout" toml:"shutdown_timeout" json:"shutdown_timeout"`
+	// MaxMidashiLen は見出し語の最大の長さ(バイト)。0 の場合は制限しない
+	MaxMidashiLen int `koanf:"max_midashi_len" toml:"max_midashi_len" json:"max_midashi_len"`
+	// TLSCert と TLSKey は TLS で使う証明書と秘密鍵のファイル。ファイルが更新された場合は自動で読み込み直す
+	TLSCert string `koanf:"tls_cert" toml:"tls_cert" json:"tls_cert"`
+	TLSKey  string `koanf:"tls_key" toml:"tls_key" json:"tls_key"`
+	// SKKTLSClientCA と AdminTLSClientCA は SKK サーバーと管理画面でクライアント証明書を検証する CA の証明書のファイル。
+	// 指定した場合はクライアント証明書を必須にする
+	SKKTLSClientCA   string `koanf:"skk_tls_client_ca" toml:"skk_tls_client_ca" json:"skk_tls_client_ca"`
+	AdminTLSClientCA string `koanf:"admin_tls_client_ca" toml:"admin_tls_client_ca" json:"admin_tls_client_ca"`
+	// SKKTLS は SKK サーバーの待ち受けで TLS を使う。変更は再起動後に反映する
+	SKKTLS bool `koanf:"skk_tls" toml:"skk_tls" json:"skk_tls"`
+	// AdminTLS は管理画面で TLS を使う。変更は再起動後に反映する
+	AdminTLS bool `koanf:"admin_tls" toml:"admin_tls" json:"admin_tls"`
+	// LogLevel はログの出力レベル(debug, info, warn, error)
+	LogLevel string `koanf:"log_level" toml:"log_level" json:"log_level"`
+	// LogFormat はログの形式(text, json)
+	LogFormat string `koanf:"log_format" toml:"log_format" json:"log_format"`
+	// LogFile はログの出力先のファイル。空の場合は標準エラー出力に出力する
+	LogFile string `koanf:"log_file" toml:"log_file" json:"log_file"`
+	// LogMaxSize はログファイルを切り替える大きさ(MB)。0 の場合は切り替えない
+	LogMaxSize int `koanf:"log_max_size" toml:"log_max_size" json:"log_max_size"`
+	// LogMaxBackups は残しておく古いログファイルの数
+	LogMaxBackups int `koanf:"log_max_backups" toml:"log_max_backups" json:"log_max_backups"`
+	// LogPrivacy はログに出力する見出し語と変換候補の扱い。off はそのまま出力し、hash は見出し語をハッシュ値にし、omit は出力しない。
+	// hash と omit では変換候補は数だけを出力する
+	LogPrivacy string `koanf:"log_privacy" toml:"log_privacy" json:"log_privacy"`
+	// AnnotateSource は変換候補の注釈に候補を返した辞書の名前を付ける
+	AnnotateSource bool `koanf:"annotate_source" toml:"annotate_source" json:"annotate_source"`
+	// DictionaryOptions は Dictionary の辞書ごとの設定
+	DictionaryOptions []DictionaryOption `koanf:"dictionary_options" toml:"dictionary_options" json:"dictionary_options"`
+}
+
+// DictionaryOption は辞書ごとの設定。Src で Dictionary の要素を指定する
+type DictionaryOption struct {
+	Src string `koanf:"src" toml:"src" json:"src"`
+	// Encoding は辞書の文字コード。空の場合は自動で判定する
+	Encoding string `koanf:"encoding" toml:"encoding" json:"encoding"`
+}
+
+// GetDictionaryOption は辞書の設定を返す。設定がない場合は初期値を返す
+func (config *Config) GetDictionaryOption(src string) DictionaryOption {
+	for _, opt := range config.DictionaryOptions {
+		if opt.Src == src {
+			return opt
+		}
+	}
+	return DictionaryOption{Src: src}
+}
+
+// 辞書の種類(DictOrderで指定する名前)
+const (
+	DictAI     = "ai"
+	DictLisp   = "lisp"
+	DictSkk    = "skk"
+	DictEmoji  = "emoji"
+	DictCalc   = "calc"
+	DictUnit   = "unit"
+	DictNumber = "number"
+	DictAbbrev = "abbrev"
+)
+
+var defaultDictOrder = []string{DictAI, DictLisp, DictCalc, DictUnit, DictSkk, DictNumber, DictAbbrev, DictEmoji}
+
+// GetDictOrder は変換に使う辞書の順番を返す。DictOrderに含まれていない辞書はデフォルトの順番で末尾に追加する
+func (config *Config) GetDictOrder() []string {
+	order := []string{}
+	seen := map[string]bool{}
+	for _, name := range append(config.DictOrder, defaultDictOrder...) {
+		name = strings.TrimSpace(name)
+		if seen[name] || !slices.Contains(defaultDictOrder, name) {
+			continue
+		}
+		seen[name] = true
+		order = append(order, name)
+	}
+
+	return order
+}
+
+// GetListenAddrs は SKK サーバーの待ち受けアドレスを返す
+func (config *Config) GetListenAddrs() []string {
+	if len(config.Listen) == 0 {
+		return []string{":" + config.Port}
+	}
+	return config.Listen
+}
+
+// GetSocketMode は Unix ドメインソケットのパーミッションを返す
+func (config *Config) GetSocketMode() (os.FileMode, error) {
+	if config.SocketMode == "" {
+		return 0600, nil
+	}
+	mode, err := strconv.ParseUint(config.SocketMode, 8, 32)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid socket_mode: %s", config.SocketMode)
+	}
+	return os.FileMode(mode) & os.ModePerm, nil
+}
+
+// GetTimeouts は SKK サーバーの待ち時間を返す
+func (config *Config) GetTimeouts() (idle, read, write time.Duration) {
+	return time.Duration(config.IdleTimeout) * time.Second,
+		time.Duration(config.ReadTimeout) * time.Second,
+		time.Duration(config.WriteTimeout) * time.Second
+}
+
+// GetShutdownTimeout は終了時に処理中のリクエストが終わるのを待つ時間を返す
+func (config *Config) GetShutdownTimeout() time.Duration {
+	return time.Duration(config.ShutdownTimeout) * time.Second
 }
 
 func (config *Config) GetCacheDir() (string, error) {
@@ -43,19 +191,85 @@ func (config *Config) GetCacheDir() (string, error) {
 	return dir, nil
 }
 
+// GetPidFile は実行中のプロセス ID を書き込むファイルのパスを返す
+func (config *Config) GetPidFile() (string, error) {
+	if config.PidFile != "" {
+		return config.PidFile, nil
+	}
+	cdir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return filepath.Join(cdir, "bragi", "bragi.pid"), nil
+}
+
+// GetLogLevel はログの出力レベルを返す
+func (config *Config) GetLogLevel() (slog.Level, error) {
+	var level slog.Level
+	if config.LogLevel == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(config.LogLevel)); err != nil {
+		return level, errors.Wrapf(err, "invalid log_level: %s", config.LogLevel)
+	}
+	return level, nil
+}
+
+// 名前にこれらを含む設定はログに値を出力しない
+var secretWords = []string{"key", "secret", "token", "password"}
+
+// redact は key が秘密の値を表す場合に v を隠して返す
+func redact(key string, v any) any {
+	key = strings.ToLower(key)
+	for _, w := range secretWords {
+		if strings.Contains(key, w) {
+			if v == "" || v == nil {
+				return v
+			}
+			return "[REDACTED]"
+		}
+	}
+	return v
+}
+
+// LogValue はログに出力する設定の値を返す。秘密の値は隠す
+func (config *Config) LogValue() slog.Value {
+	buf, err := json.Marshal(config)
+	if err != nil {
+		return slog.StringValue(err.Error())
+	}
+	m := map[string]any{}
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return slog.StringValue(err.Error())
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, slog.Any(key, redact(key, m[key])))
+	}
+	return slog.GroupValue(attrs...)
+}
+
 func LoadConfig(filename string) (*Config, error) {
 	k := koanf.New(".")
 
 	// 設定ファイル(TOML)から読み込み
 	if _, err := os.Stat(filename); err == nil {
-		k.Load(file.Provider(filename), toml.Parser())
+		if err := k.Load(file.Provider(filename), toml.Parser()); err != nil {
+			return nil, errors.Wrapf(err, "failed to load %s", filename)
+		}
 	}
 
 	// 環境変数から読み込み
 	k.Load(env.ProviderWithValue("BRG_", ".", func(s, v string) (string, interface{}) {
 		key := strings.ToLower(strings.TrimPrefix(s, "BRG_"))
-		log.Printf("%s => %s: %s\n", s, key, v)
-		if key == "dictionary" {
+		slog.Debug("config from environment", "env", s, "key", key, "value", redact(key, v))
+		if key == "dictionary" || key == "dict_order" || key == "listen" || key == "allow" || key == "deny" {
 			return key, strings.Split(v, ",")
 		}
 
@@ -68,11 +282,31 @@ func LoadConfig(filename string) (*Config, error) {
 		"admin_port":       "8080",
 		"use_ai":           true,
 		"use_lisp":         true,
+		"use_emoji":        false,
+		"use_calc":         true,
+		"use_unit":         true,
+		"use_number":       true,
+		"use_abbrev":       true,
 		"year_format":      "2006年",
 		"month_format":     "2006年1月",
 		"date_format":      "2006年1月2日",
 		"date_time_format": "2006年1月2日 15時4分",
 		"time_zone":        "Asia/Tokyo",
+		"dict_order":       defaultDictOrder,
+		"lenient_load":     true,
+		"annotate_source":  false,
+		"socket_mode":      "0600",
+		"idle_timeout":     0,
+		"read_timeout":     10,
+		"write_timeout":    10,
+		"max_midashi_len":  256,
+		"shutdown_timeout": 10,
+		"server_name":      "bragi",
+		"log_level":        "info",
+		"log_format":       "text",
+		"log_max_size":     10,
+		"log_max_backups":  5,
+		"log_privacy":      "hash",
 	}
 	for key, val := range defaults {
 		if !k.Exists(key) {
@@ -0,0 +1,57 @@
+package dict
+
+import (
+	"bufio"
+	_ "embed"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//go:embed data/SKK-JISYO.abbrev
+var abbrevData string
+
+// IsAbbrev は見出し語が abbrev モードの ASCII の見出し語かどうかを返す
+func IsAbbrev(word string) bool {
+	letter := false
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		if c >= 0x80 {
+			return false
+		}
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			letter = true
+		}
+	}
+	return letter
+}
+
+// AbbrevDict は abbrev モードの英単語を辞書に従ってカタカナに変換する
+type AbbrevDict struct {
+	dictMap DicMap
+}
+
+func (d *AbbrevDict) Convert(word string) ([]string, error) {
+	if !IsAbbrev(word) {
+		return []string{}, nil
+	}
+
+	words := []string{}
+	if ws, ok := d.dictMap[strings.ToLower(word)]; ok {
+		for _, w := range ws {
+			words = append(words, w.String())
+		}
+	}
+
+	return words, nil
+}
+
+func NewAbbrevDict() (*AbbrevDict, error) {
+	r := &Reader{scanner: bufio.NewScanner(strings.NewReader(abbrevData))}
+	m, err := r.ReadMap()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &AbbrevDict{dictMap: m}, nil
+}
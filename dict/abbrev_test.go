@@ -0,0 +1,58 @@
+package dict
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsAbbrev(t *testing.T) {
+	tests := []struct {
+		word string
+		want bool
+	}{
+		{"api", true},
+		{"Git", true},
+		{"c++", true},
+		{"x11", true},
+		{"123", false},
+		{"", false},
+		{"かな", false},
+		{"aかな", false},
+	}
+	for _, tt := range tests {
+		if got := IsAbbrev(tt.word); got != tt.want {
+			t.Errorf("IsAbbrev(%q) = %v, want %v", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestAbbrevDictConvert(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"bug", []string{"バグ;"}},
+		{"chrome", []string{"クローム;", "Chrome;"}},
+		// 大文字で入力しても同じ候補を返す
+		{"Chrome", []string{"クローム;", "Chrome;"}},
+		// 辞書にない英単語はローマ字として読まない
+		{"api", []string{}},
+		{"konnichiwa", []string{}},
+		{"かな", []string{}},
+		{"123", []string{}},
+	}
+
+	d, err := NewAbbrevDict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		got, err := d.Convert(tt.word)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Convert(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package dict
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zipMagic   = []byte("PK\x03\x04")
+)
+
+// tar のヘッダの ustar の位置
+const tarMagicOffset = 257
+
+// splitArchiveMember は「アーカイブ#メンバー名」の形式の辞書の指定を分割する。
+// ローカルファイルで # を含む名前のファイルが存在する場合は分割しない
+func splitArchiveMember(src string) (string, string) {
+	i := strings.LastIndex(src, "#")
+	if i < 0 {
+		return src, ""
+	}
+	if !isURL(src) {
+		if _, err := os.Stat(src); err == nil {
+			return src, ""
+		}
+	}
+	return src[:i], src[i+1:]
+}
+
+// LocalPath は辞書の指定がローカルファイルの場合にそのパスを返す。アーカイブの場合はアーカイブのパスを返す
+func LocalPath(src string) (string, bool) {
+	if isURL(src) {
+		return "", false
+	}
+	fpath, _ := splitArchiveMember(src)
+	return fpath, true
+}
+
+// decompress は gzip, bzip2, xz で圧縮されている場合に展開する。圧縮されていない場合はそのまま返す
+func decompress(br *bufio.Reader) (*bufio.Reader, error) {
+	header, _ := br.Peek(len(xzMagic))
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return bufio.NewReader(gr), nil
+	case bytes.HasPrefix(header, bzip2Magic):
+		return bufio.NewReader(bzip2.NewReader(br)), nil
+	case bytes.HasPrefix(header, xzMagic):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return bufio.NewReader(xr), nil
+	}
+	return br, nil
+}
+
+func isTar(br *bufio.Reader) bool {
+	header, err := br.Peek(tarMagicOffset + 5)
+	return err == nil && string(header[tarMagicOffset:]) == "ustar"
+}
+
+func isZip(fpath string) bool {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, len(zipMagic))
+	_, err = io.ReadFull(f, header)
+	return err == nil && bytes.Equal(header, zipMagic)
+}
+
+// archiveMember はアーカイブ内のファイル
+type archiveMember struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// cleanMemberName は「./」などを取り除いたアーカイブ内のパスを返す
+func cleanMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// selectMember はアーカイブ内のファイルから member に一致するものを選ぶ。
+// member はパスかファイル名で指定する。空の場合はファイルが1つだけのアーカイブに限りそれを選ぶ
+func selectMember(archive, member string, ms []archiveMember) (*archiveMember, error) {
+	if member == "" {
+		if len(ms) == 1 {
+			return &ms[0], nil
+		}
+		names := make([]string, len(ms))
+		for i, m := range ms {
+			names[i] = m.name
+		}
+		return nil, fmt.Errorf("archive has multiple files, specify one as %s#<name>: %s", archive, strings.Join(names, ", "))
+	}
+
+	member = cleanMemberName(member)
+	var found *archiveMember
+	for i, m := range ms {
+		if cleanMemberName(m.name) == member {
+			return &ms[i], nil
+		}
+		if found == nil && path.Base(m.name) == member {
+			found = &ms[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s is not found in %s", member, archive)
+	}
+	return found, nil
+}
+
+func zipMembers(zr *zip.Reader) []archiveMember {
+	ms := []archiveMember{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		ms = append(ms, archiveMember{name: f.Name, open: f.Open})
+	}
+	return ms
+}
+
+// extractTar は tar の中から member を探して w に書き出す。
+// tar は先頭から順番にしか読めないため、メンバー名を集めてから選び直して再度読む
+func extractTar(fpath, member string, w io.Writer) error {
+	read := func(fn func(tr *tar.Reader, h *tar.Header) (bool, error)) error {
+		f, err := os.Open(fpath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer f.Close()
+
+		br, err := decompress(bufio.NewReader(f))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		tr := tar.NewReader(br)
+		for {
+			h, err := tr.Next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return errors.WithStack(err)
+			}
+			if h.Typeflag != tar.TypeReg {
+				continue
+			}
+			if done, err := fn(tr, h); done || err != nil {
+				return err
+			}
+		}
+	}
+
+	ms := []archiveMember{}
+	if err := read(func(_ *tar.Reader, h *tar.Header) (bool, error) {
+		ms = append(ms, archiveMember{name: h.Name})
+		return false, nil
+	}); err != nil {
+		return err
+	}
+	m, err := selectMember(fpath, member, ms)
+	if err != nil {
+		return err
+	}
+
+	return read(func(tr *tar.Reader, h *tar.Header) (bool, error) {
+		if h.Name != m.name {
+			return false, nil
+		}
+		_, err := io.Copy(w, tr)
+		return true, errors.WithStack(err)
+	})
+}
+
+func extractZip(fpath, member string, w io.Writer) error {
+	zr, err := zip.OpenReader(fpath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer zr.Close()
+
+	m, err := selectMember(fpath, member, zipMembers(&zr.Reader))
+	if err != nil {
+		return err
+	}
+	r, err := m.open()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return errors.WithStack(err)
+}
+
+// archiveKind はファイルが zip か tar(圧縮されたものを含む)かを判定する
+func archiveKind(fpath string) (string, error) {
+	if isZip(fpath) {
+		return "zip", nil
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+
+	br, err := decompress(bufio.NewReader(f))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if isTar(br) {
+		return "tar", nil
+	}
+	return "", nil
+}
+
+// extractArchive は fpath がアーカイブの場合に member を dir に展開し、展開したファイルのパスを返す。
+// 展開済みのファイルがアーカイブより新しい場合はそれを使う。アーカイブでない場合は fpath をそのまま返す
+func extractArchive(fpath, member, dir string) (string, error) {
+	kind, err := archiveKind(fpath)
+	if err != nil {
+		return "", err
+	}
+	if kind == "" {
+		if member != "" {
+			return "", fmt.Errorf("not an archive: %s#%s", fpath, member)
+		}
+		return fpath, nil
+	}
+
+	name := member
+	if name == "" {
+		name = "default"
+	}
+	out := filepath.Join(dir, filepath.Base(fpath)+".d", filepath.FromSlash(cleanMemberName(name)))
+
+	if ai, err := os.Stat(fpath); err == nil {
+		if oi, err := os.Stat(out); err == nil && !oi.ModTime().Before(ai.ModTime()) {
+			return out, nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return "", errors.WithStack(err)
+	}
+	// 展開中のファイルを読み込まないように一時ファイルに書き出してから名前を変える
+	tmp, err := os.CreateTemp(filepath.Dir(out), ".extract-*")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if kind == "zip" {
+		err = extractZip(fpath, member, tmp)
+	} else {
+		err = extractTar(fpath, member, tmp)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := os.Rename(tmp.Name(), out); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return out, nil
+}
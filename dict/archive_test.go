@@ -0,0 +1,241 @@
+package dict
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ulikunitz/xz"
+)
+
+// testArchiveFiles はテストで作るアーカイブに入れるファイル
+var testArchiveFiles = []struct {
+	name, body string
+}{
+	{"SKK-JISYO.L", "かんじ /漢字/\n"},
+	{"sub/SKK-JISYO.jinmei", "やまだ /山田/\n"},
+	{"../evil", "あく /悪/\n"},
+}
+
+func writeZip(t *testing.T, fpath string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range testArchiveFiles {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, f.body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fpath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tarBytes(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range testArchiveFiles {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, f.body)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeTarGz(t *testing.T, fpath string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write(tarBytes(t))
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fpath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarXz(t *testing.T, fpath string) {
+	t.Helper()
+	var buf bytes.Buffer
+	xw, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xw.Write(tarBytes(t))
+	if err := xw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fpath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTarBz2 は標準ライブラリに bzip2 の圧縮がないため bzip2 コマンドで圧縮する
+func writeTarBz2(t *testing.T, fpath string) {
+	t.Helper()
+	bz, err := exec.LookPath("bzip2")
+	if err != nil {
+		t.Skip("bzip2 command is not found")
+	}
+	cmd := exec.Command(bz, "-c")
+	cmd.Stdin = bytes.NewReader(tarBytes(t))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fpath, out, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var testArchiveWriters = []struct {
+	name  string
+	write func(t *testing.T, fpath string)
+}{
+	{"dict.zip", writeZip},
+	{"dict.tar.gz", writeTarGz},
+	{"dict.tar.bz2", writeTarBz2},
+	{"dict.tar.xz", writeTarXz},
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		member string
+		want   string
+	}{
+		{"SKK-JISYO.L", "かんじ /漢字/\n"},
+		{"sub/SKK-JISYO.jinmei", "やまだ /山田/\n"},
+		// パスを省略してファイル名だけでも指定できる
+		{"SKK-JISYO.jinmei", "やまだ /山田/\n"},
+		{"./sub/SKK-JISYO.jinmei", "やまだ /山田/\n"},
+		{"evil", "あく /悪/\n"},
+	}
+
+	for _, aw := range testArchiveWriters {
+		t.Run(aw.name, func(t *testing.T) {
+			src, dir := t.TempDir(), t.TempDir()
+			fpath := filepath.Join(src, aw.name)
+			aw.write(t, fpath)
+
+			for _, tt := range tests {
+				out, err := extractArchive(fpath, tt.member, dir)
+				if err != nil {
+					t.Errorf("%s: %v", tt.member, err)
+					continue
+				}
+				if rel, err := filepath.Rel(filepath.Join(dir, aw.name+".d"), out); err != nil || strings.HasPrefix(rel, "..") {
+					t.Errorf("%s: extracted outside the cache directory: %s", tt.member, out)
+				}
+				b, err := os.ReadFile(out)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != tt.want {
+					t.Errorf("%s: got %q, want %q", tt.member, b, tt.want)
+				}
+			}
+
+			if _, err := extractArchive(fpath, "SKK-JISYO.none", dir); err == nil {
+				t.Errorf("missing member is extracted")
+			}
+			// 複数のファイルを含むアーカイブはメンバーを省略できない
+			if _, err := extractArchive(fpath, "", dir); err == nil {
+				t.Errorf("member is not required")
+			}
+		})
+	}
+}
+
+func TestExtractArchiveTraversal(t *testing.T) {
+	src, dir := t.TempDir(), t.TempDir()
+	fpath := filepath.Join(src, "dict.zip")
+	writeZip(t, fpath)
+
+	// アーカイブの外を指すメンバー名でも展開先のディレクトリの外には書き出さない
+	out, err := extractArchive(fpath, "../../evil", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "dict.zip.d", "evil"); out != want {
+		t.Errorf("extracted to %s, want %s", out, want)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "evil")); err == nil {
+		t.Errorf("file is written outside the cache directory")
+	}
+}
+
+func TestExtractArchiveCache(t *testing.T) {
+	src, dir := t.TempDir(), t.TempDir()
+	fpath := filepath.Join(src, "dict.tar.gz")
+	writeTarGz(t, fpath)
+
+	out, err := extractArchive(fpath, "SKK-JISYO.L", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// アーカイブより新しい展開済みのファイルはそのまま使う
+	if err := os.WriteFile(out, []byte("cached"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out2, err := extractArchive(fpath, "SKK-JISYO.L", dir); err != nil || out2 != out {
+		t.Fatalf("extractArchive() = %s, %v", out2, err)
+	}
+	if b, _ := os.ReadFile(out); string(b) != "cached" {
+		t.Errorf("cache is not reused: %q", b)
+	}
+
+	// アーカイブが更新された場合は展開し直す
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(fpath, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extractArchive(fpath, "SKK-JISYO.L", dir); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(out); string(b) != "かんじ /漢字/\n" {
+		t.Errorf("archive is not extracted again: %q", b)
+	}
+}
+
+func TestSplitArchiveMember(t *testing.T) {
+	dir := t.TempDir()
+	hashed := filepath.Join(dir, "dict#1.txt")
+	if err := os.WriteFile(hashed, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		src, fpath, member string
+	}{
+		{"/tmp/dict.zip#SKK-JISYO.L", "/tmp/dict.zip", "SKK-JISYO.L"},
+		{"/tmp/SKK-JISYO.L", "/tmp/SKK-JISYO.L", ""},
+		{"https://example.com/dict.tar.gz#sub/SKK-JISYO.L", "https://example.com/dict.tar.gz", "sub/SKK-JISYO.L"},
+		// # を含む名前のファイルが存在する場合は分割しない
+		{hashed, hashed, ""},
+	}
+	for _, tt := range tests {
+		fpath, member := splitArchiveMember(tt.src)
+		if fpath != tt.fpath || member != tt.member {
+			t.Errorf("splitArchiveMember(%q) = %q, %q, want %q, %q", tt.src, fpath, member, tt.fpath, tt.member)
+		}
+	}
+}
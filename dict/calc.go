@@ -0,0 +1,293 @@
+package dict
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/width"
+)
+
+const (
+	// 式として受け付ける最大の長さ(バイト)
+	maxCalcLength = 64
+	// 括弧や単項演算子の最大の入れ子の深さ
+	maxCalcDepth = 16
+	// 結果として扱う最大の絶対値。float64で整数を正確に表せる範囲に収める
+	maxCalcValue = 1e15
+	// 結果の最大の有効桁数と小数点以下の桁数
+	maxCalcDigits   = 15
+	maxCalcDecimals = 10
+)
+
+var calcFuncs = map[string]func(float64) float64{
+	"sqrt":  math.Sqrt,
+	"abs":   math.Abs,
+	"floor": math.Floor,
+	"ceil":  math.Ceil,
+	"round": math.Round,
+	"log":   math.Log10,
+	"ln":    math.Log,
+}
+
+var calcConsts = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+type CalcDict struct{}
+
+func (d *CalcDict) Convert(word string) ([]string, error) {
+	if len(word) > maxCalcLength {
+		return []string{}, nil
+	}
+	expr := strings.NewReplacer("×", "*", "÷", "/", "−", "-").Replace(width.Narrow.String(word))
+	if !strings.ContainsAny(expr, "+-*/%^(") {
+		// 数値だけのものは計算式として扱わない
+		return []string{}, nil
+	}
+
+	p := &calcParser{src: expr}
+	v, err := p.parse()
+	if err != nil {
+		return []string{}, nil
+	}
+	if p.ops == 0 {
+		// 「-5」や「(3)」のように演算のない式は数値の読みと区別できないため変換しない
+		return []string{}, nil
+	}
+
+	return formatCalcResult(v), nil
+}
+
+func formatCalcResult(v float64) []string {
+	ip := math.Trunc(math.Abs(v))
+	decimals := maxCalcDigits - len(strconv.FormatFloat(ip, 'f', 0, 64))
+	decimals = min(max(decimals, 0), maxCalcDecimals)
+
+	s := formatDecimal(v, decimals)
+
+	words := []string{s}
+	if g := groupDigits(s); g != s {
+		words = append(words, g)
+	}
+	if sign, ip, fp := splitSign(s); fp == "" {
+		if k := kanjiNumeral(ip); k != "" {
+			if sign != "" {
+				k = "マイナス" + k
+			}
+			words = append(words, k)
+		}
+	}
+
+	return words
+}
+
+// calcParser は四則演算、べき乗、剰余、関数呼び出しを扱う再帰下降パーサー
+type calcParser struct {
+	src   string
+	pos   int
+	depth int
+	// 二項演算子と関数呼び出しの数
+	ops int
+}
+
+func (p *calcParser) parse() (float64, error) {
+	v, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.src) {
+		return 0, fmt.Errorf("unexpected character at %d: %q", p.pos, p.src[p.pos:])
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(v) >= maxCalcValue {
+		return 0, fmt.Errorf("result out of range: %v", v)
+	}
+
+	return v, nil
+}
+
+func (p *calcParser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *calcParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *calcParser) enter() error {
+	p.depth++
+	if p.depth > maxCalcDepth {
+		return fmt.Errorf("expression too deep")
+	}
+	return nil
+}
+
+func (p *calcParser) leave() {
+	p.depth--
+}
+
+// expr := term (('+' | '-') term)*
+func (p *calcParser) expr() (float64, error) {
+	v, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.peek() {
+		case '+':
+			p.pos++
+			p.ops++
+			r, err := p.term()
+			if err != nil {
+				return 0, err
+			}
+			v += r
+		case '-':
+			p.pos++
+			p.ops++
+			r, err := p.term()
+			if err != nil {
+				return 0, err
+			}
+			v -= r
+		default:
+			return v, nil
+		}
+	}
+}
+
+// term := unary (('*' | '/' | '%') unary)*
+func (p *calcParser) term() (float64, error) {
+	v, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return v, nil
+		}
+		if op == '*' && strings.HasPrefix(p.src[p.pos:], "**") {
+			// べき乗はunaryの中で処理する
+			return v, nil
+		}
+		p.pos++
+		p.ops++
+		r, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			v *= r
+		case '/':
+			if r == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			v /= r
+		case '%':
+			if r == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			v = math.Mod(v, r)
+		}
+	}
+}
+
+// unary := ('+' | '-') unary | power
+func (p *calcParser) unary() (float64, error) {
+	if err := p.enter(); err != nil {
+		return 0, err
+	}
+	defer p.leave()
+
+	switch p.peek() {
+	case '+':
+		p.pos++
+		return p.unary()
+	case '-':
+		p.pos++
+		v, err := p.unary()
+		return -v, err
+	}
+	return p.power()
+}
+
+// power := primary (('^' | '**') unary)?
+func (p *calcParser) power() (float64, error) {
+	v, err := p.primary()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case p.peek() == '^':
+		p.pos++
+	case strings.HasPrefix(p.src[p.pos:], "**"):
+		p.pos += 2
+	default:
+		return v, nil
+	}
+	p.ops++
+	e, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(v, e), nil
+}
+
+// primary := number | '(' expr ')' | name '(' expr ')' | name
+func (p *calcParser) primary() (float64, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		v, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing ')' at %d", p.pos)
+		}
+		p.pos++
+		return v, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		return strconv.ParseFloat(p.src[start:p.pos], 64)
+	case c >= 'a' && c <= 'z':
+		start := p.pos
+		for p.pos < len(p.src) && p.src[p.pos] >= 'a' && p.src[p.pos] <= 'z' {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		if f, ok := calcFuncs[name]; ok && p.peek() == '(' {
+			p.ops++
+			v, err := p.primary()
+			if err != nil {
+				return 0, err
+			}
+			return f(v), nil
+		}
+		if v, ok := calcConsts[name]; ok {
+			return v, nil
+		}
+		return 0, fmt.Errorf("unknown name: %s", name)
+	}
+
+	return 0, fmt.Errorf("unexpected character at %d", p.pos)
+}
+
+func NewCalcDict() *CalcDict {
+	return &CalcDict{}
+}
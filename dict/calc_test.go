@@ -0,0 +1,60 @@
+package dict
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCalcDictConvert(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		// 演算子の優先順位と結合
+		{"1+2*3", []string{"7", "七"}},
+		{"(1+2)*3", []string{"9", "九"}},
+		{"10-4-3", []string{"3", "三"}},
+		{"2^3^2", []string{"512", "五百十二"}},
+		{"-2^2", []string{"-4", "マイナス四"}},
+		{"2**10", []string{"1024", "1,024", "千二十四"}},
+		{"7%3", []string{"1", "一"}},
+		{"100/7", []string{"14.2857142857"}},
+		{"0.1+0.2", []string{"0.3"}},
+		{"1000000*1000", []string{"1000000000", "1,000,000,000", "十億"}},
+		// 関数、定数、全角の記号
+		{"sqrt(2)", []string{"1.4142135624"}},
+		{"pi*2", []string{"6.2831853072"}},
+		{"１＋２", []string{"3", "三"}},
+		{"3×4", []string{"12", "十二"}},
+		{"10÷4", []string{"2.5"}},
+		// 0 での除算と範囲外の結果
+		{"1/0", []string{}},
+		{"5%0", []string{}},
+		{"sqrt(-1)", []string{}},
+		{"10^20", []string{}},
+		// 単項演算子と括弧だけの式は数値の読みとして扱う
+		{"-5", []string{}},
+		{"+3", []string{}},
+		{"--5", []string{}},
+		{"(3)", []string{}},
+		{"5", []string{}},
+		// 式ではないもの
+		{"abc", []string{}},
+		{"1+", []string{}},
+		{"かんじ", []string{}},
+		{strings.Repeat("(", maxCalcDepth+1) + "1" + strings.Repeat(")", maxCalcDepth+1) + "+1", []string{}},
+		{strings.Repeat("1+", maxCalcLength), []string{}},
+	}
+
+	d := NewCalcDict()
+	for _, tt := range tests {
+		got, err := d.Convert(tt.word)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Convert(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
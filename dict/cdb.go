@@ -0,0 +1,195 @@
+package dict
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// CDB の先頭にある256個のハッシュ表の位置と長さ
+const cdbHeaderSize = 256 * 8
+
+// cdbStore は skkdic-expr2 や makeskkcdbdic で作成した CDB 形式の辞書。
+// キーは見出し語、値は「/候補/候補/」の形式
+type cdbStore struct {
+	buf    []byte
+	enc    encoding.Encoding
+	closer func() error
+}
+
+func cdbHash(key []byte) uint32 {
+	h := uint32(5381)
+	for _, c := range key {
+		h = ((h << 5) + h) ^ uint32(c)
+	}
+	return h
+}
+
+func (c *cdbStore) uint32At(pos int) (uint32, bool) {
+	if pos < 0 || pos+4 > len(c.buf) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(c.buf[pos:]), true
+}
+
+// record は pos にあるレコードのキーと値を返す
+func (c *cdbStore) record(pos int) ([]byte, []byte, bool) {
+	klen, ok1 := c.uint32At(pos)
+	dlen, ok2 := c.uint32At(pos + 4)
+	end := pos + 8 + int(klen) + int(dlen)
+	if !ok1 || !ok2 || end > len(c.buf) || end < pos {
+		return nil, nil, false
+	}
+	key := c.buf[pos+8 : pos+8+int(klen)]
+	return key, c.buf[pos+8+int(klen) : end], true
+}
+
+func (c *cdbStore) get(key []byte) ([]byte, bool) {
+	h := cdbHash(key)
+	tpos, _ := c.uint32At(int(h%256) * 8)
+	tlen, _ := c.uint32At(int(h%256)*8 + 4)
+	if tlen == 0 {
+		return nil, false
+	}
+
+	start := int((h >> 8) % tlen)
+	for i := 0; i < int(tlen); i++ {
+		slot := int(tpos) + ((start+i)%int(tlen))*8
+		sh, ok1 := c.uint32At(slot)
+		rpos, ok2 := c.uint32At(slot + 4)
+		if !ok1 || !ok2 || rpos == 0 {
+			return nil, false
+		}
+		if sh != h {
+			continue
+		}
+		if k, v, ok := c.record(int(rpos)); ok && string(k) == string(key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// words は CDB の値を候補に変換する
+func (c *cdbStore) words(v []byte) []Word {
+	s, err := c.enc.NewDecoder().String(string(v))
+	if err != nil {
+		return nil
+	}
+	e, err := parseLine("_ " + s)
+	if err != nil || e == nil {
+		return nil
+	}
+	return e.Words
+}
+
+func (c *cdbStore) Lookup(label string) ([]Word, bool, error) {
+	key, err := c.enc.NewEncoder().String(label)
+	if err != nil {
+		return nil, false, nil
+	}
+	v, ok := c.get([]byte(key))
+	if !ok {
+		return nil, false, nil
+	}
+	return c.words(v), true, nil
+}
+
+// each は全てのレコードを先頭から順番に fn に渡す
+func (c *cdbStore) each(fn func(key, value []byte) bool) {
+	end, ok := c.uint32At(0)
+	if !ok {
+		return
+	}
+	for pos := cdbHeaderSize; pos < int(end); {
+		k, v, ok := c.record(pos)
+		if !ok || !fn(k, v) {
+			return
+		}
+		pos += 8 + len(k) + len(v)
+	}
+}
+
+// Prefix は CDB では前方一致で検索できないため全てのレコードを走査する
+func (c *cdbStore) Prefix(prefix string, fn func(label string, words []Word) bool) {
+	type kv struct {
+		label string
+		value []byte
+	}
+	kvs := []kv{}
+	dec := c.enc.NewDecoder()
+	c.each(func(k, v []byte) bool {
+		label, err := dec.String(string(k))
+		if err == nil && strings.HasPrefix(label, prefix) {
+			kvs = append(kvs, kv{label, v})
+		}
+		return true
+	})
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].label < kvs[j].label })
+
+	for _, e := range kvs {
+		if !fn(e.label, c.words(e.value)) {
+			return
+		}
+	}
+}
+
+func (c *cdbStore) Len() int {
+	n := 0
+	for i := 0; i < 256; i++ {
+		tlen, _ := c.uint32At(i*8 + 4)
+		n += int(tlen)
+	}
+	// ハッシュ表の長さはレコード数の2倍
+	return n / 2
+}
+
+func (c *cdbStore) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	closer := c.closer
+	c.closer = nil
+	c.buf = nil
+	return closer()
+}
+
+// guessEncoding はレコードの内容から CDB の文字コードを判定する。
+// SKK の CDB 辞書は EUC-JP のものが多いため、UTF-8 として正しくない場合は EUC-JP とする
+func (c *cdbStore) guessEncoding() encoding.Encoding {
+	enc := encoding.Encoding(unicode.UTF8)
+	n := 0
+	c.each(func(k, v []byte) bool {
+		if !utf8.Valid(k) || !utf8.Valid(v) {
+			enc = japanese.EUCJP
+			return false
+		}
+		n++
+		return n < 1000
+	})
+	return enc
+}
+
+// OpenCDB は CDB 形式の辞書ファイルを開く
+func OpenCDB(path string) (*SkkDict, error) {
+	buf, closer, err := mapFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(buf) < cdbHeaderSize {
+		closer()
+		return nil, fmt.Errorf("invalid cdb file: %s", path)
+	}
+
+	c := &cdbStore{buf: buf, closer: closer}
+	c.enc = c.guessEncoding()
+
+	return &SkkDict{dictMap: c, abbrevMap: c}, nil
+}
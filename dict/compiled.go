@@ -0,0 +1,130 @@
+package dict
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Bragi のコンパイル済み辞書は先頭のマジックの後に通常の見出し語と abbrev の見出し語の Index を並べた形式
+const compiledMagic = "BRGDIC01"
+
+func toIndex(s Store) (*Index, error) {
+	switch st := s.(type) {
+	case *Index:
+		return st, nil
+	case DicMap:
+		return NewIndex(st), nil
+	}
+	return nil, fmt.Errorf("unsupported store: %T", s)
+}
+
+// WriteTo は辞書を Bragi のコンパイル済み辞書の形式で書き出す
+func (d *SkkDict) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, compiledMagic)
+	if err != nil {
+		return int64(n), errors.WithStack(err)
+	}
+
+	total := int64(n)
+	for _, s := range []Store{d.dictMap, d.abbrevMap} {
+		idx, err := toIndex(s)
+		if err != nil {
+			return total, errors.WithStack(err)
+		}
+		m, err := idx.WriteTo(w)
+		total += m
+		if err != nil {
+			return total, errors.WithStack(err)
+		}
+	}
+
+	return total, nil
+}
+
+// Close は mmap した辞書を解放する
+func (d *SkkDict) Close() error {
+	var err error
+	for _, s := range []Store{d.dictMap, d.abbrevMap} {
+		if c, ok := s.(io.Closer); ok {
+			if cerr := c.Close(); cerr != nil && err == nil {
+				err = errors.WithStack(cerr)
+			}
+		}
+	}
+	return err
+}
+
+// OpenCompiledDict は bragi dict compile で作成した辞書ファイルを解析せずに mmap して開く
+func OpenCompiledDict(path string) (*SkkDict, error) {
+	buf, closer, err := mapFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !strings.HasPrefix(string(buf[:min(len(buf), len(compiledMagic))]), compiledMagic) {
+		closer()
+		return nil, fmt.Errorf("invalid compiled dictionary: %s", path)
+	}
+
+	p := len(compiledMagic)
+	dm, n, err := parseIndex(buf[p:])
+	if err != nil {
+		closer()
+		return nil, errors.Wrap(err, path)
+	}
+	p += n
+	am, _, err := parseIndex(buf[p:])
+	if err != nil {
+		closer()
+		return nil, errors.Wrap(err, path)
+	}
+	// 2つの索引は同じ領域を参照しているため、解放は片方だけで行う
+	dm.closer = closer
+
+	return &SkkDict{dictMap: dm, abbrevMap: am}, nil
+}
+
+// CompileSkkDict は SKK 辞書を読み込み、コンパイル済み辞書の形式で w に書き出す
+func CompileSkkDict(r *Reader, w io.Writer) error {
+	sd, err := readSkkDict(r, &SkkDictOptions{Compact: true})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := sd.WriteTo(w); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// binaryDictKind は辞書ファイルがコンパイル済み辞書か CDB かを判定する
+func binaryDictKind(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(compiledMagic))
+	if n, _ := io.ReadFull(f, magic); n == len(magic) && string(magic) == compiledMagic {
+		return "bragi"
+	}
+	if strings.EqualFold(filepath.Ext(path), ".cdb") {
+		return "cdb"
+	}
+	return ""
+}
+
+// openBinaryDict はコンパイル済み辞書か CDB の場合に辞書を開く。それ以外の場合は nil を返す
+func openBinaryDict(path string) (*SkkDict, error) {
+	switch binaryDictKind(path) {
+	case "bragi":
+		return OpenCompiledDict(path)
+	case "cdb":
+		return OpenCDB(path)
+	}
+	return nil, nil
+}
@@ -0,0 +1,98 @@
+package dict
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//go:generate go run gen_emoji.go -ja data/cldr/annotations/ja/annotations.json -en data/cldr/annotations/en/annotations.json -emoji-data data/emoji-data.txt -gemoji data/gemoji.json -yomi data/emoji-yomi.tsv -o data/emoji.tsv
+
+//go:embed data/emoji.tsv
+var emojiData string
+
+// 肌の色の修飾子(U+1F3FB〜U+1F3FF)とその注釈
+var skinTones = []struct {
+	Modifier string
+	Desc     string
+}{
+	{"\U0001F3FB", "薄い肌色"},
+	{"\U0001F3FC", "やや薄い肌色"},
+	{"\U0001F3FD", "中間の肌色"},
+	{"\U0001F3FE", "やや濃い肌色"},
+	{"\U0001F3FF", "濃い肌色"},
+}
+
+type EmojiDict struct {
+	dictMap DicMap
+}
+
+func (d *EmojiDict) Convert(word string) ([]string, error) {
+	ws, ok := d.dictMap[word]
+	if !ok {
+		return []string{}, nil
+	}
+
+	words := make([]string, len(ws))
+	for i, w := range ws {
+		words[i] = w.String()
+	}
+
+	return words, nil
+}
+
+// withSkinTone は絵文字の先頭の文字の直後に肌の色の修飾子を挿入する
+func withSkinTone(emoji, modifier string) string {
+	rs := []rune(strings.ReplaceAll(emoji, "\uFE0F", ""))
+	return string(rs[:1]) + modifier + string(rs[1:])
+}
+
+func parseEmojiData(data string) (DicMap, error) {
+	m := DicMap{}
+
+	sc := bufio.NewScanner(strings.NewReader(data))
+	n := 0
+	for sc.Scan() {
+		n++
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cols := strings.Split(line, "\t")
+		if len(cols) != 5 {
+			return nil, fmt.Errorf("invalid emoji data at line %d: %s", n, line)
+		}
+
+		words := []Word{{Text: cols[0], Desc: cols[2]}}
+		if cols[1] == "1" {
+			for _, st := range skinTones {
+				words = append(words, Word{
+					Text: withSkinTone(cols[0], st.Modifier),
+					Desc: cols[2] + ": " + st.Desc,
+				})
+			}
+		}
+
+		keys := append(strings.Fields(cols[3]), strings.Fields(cols[4])...)
+		for _, k := range keys {
+			m[k] = append(m[k], words...)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return m, nil
+}
+
+func NewEmojiDict() (*EmojiDict, error) {
+	m, err := parseEmojiData(emojiData)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &EmojiDict{dictMap: m}, nil
+}
@@ -0,0 +1,86 @@
+package dict
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEmojiDictConvert(t *testing.T) {
+	d, err := NewEmojiDict()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"すし", []string{"🍣;すし"}},
+		{":smile:", []string{"😄;目を細めて笑う"}},
+		{":grinning_face_with_smiling_eyes:", []string{"😄;目を細めて笑う"}},
+		// 複数の絵文字に同じ読みがある場合はすべて候補にする
+		{"えがお", []string{"😀;にっこり笑う", "😃;大きな目で笑う", "😄;目を細めて笑う", "🙂;ほほえむ"}},
+		// 肌の色を変更できる絵文字は肌の色ごとの候補も返す
+		{"さむずあっぷ", []string{
+			"👍;サムズアップ",
+			"👍🏻;サムズアップ: 薄い肌色",
+			"👍🏼;サムズアップ: やや薄い肌色",
+			"👍🏽;サムズアップ: 中間の肌色",
+			"👍🏾;サムズアップ: やや濃い肌色",
+			"👍🏿;サムズアップ: 濃い肌色",
+		}},
+		{"ぴーす", []string{
+			"✌️;ピースサイン",
+			"✌🏻;ピースサイン: 薄い肌色",
+			"✌🏼;ピースサイン: やや薄い肌色",
+			"✌🏽;ピースサイン: 中間の肌色",
+			"✌🏾;ピースサイン: やや濃い肌色",
+			"✌🏿;ピースサイン: 濃い肌色",
+		}},
+		{"そんざいしない", []string{}},
+	}
+
+	for _, tt := range tests {
+		got, err := d.Convert(tt.word)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Convert(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+// TestEmojiDataGenerated は data/emoji.tsv が gen_emoji.go で生成した結果と一致することを確かめる
+func TestEmojiDataGenerated(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go run in short mode")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	out := filepath.Join(t.TempDir(), "emoji.tsv")
+	cmd := exec.Command(gobin, "run", "gen_emoji.go",
+		"-ja", "data/cldr/annotations/ja/annotations.json",
+		"-en", "data/cldr/annotations/en/annotations.json",
+		"-emoji-data", "data/emoji-data.txt",
+		"-gemoji", "data/gemoji.json",
+		"-yomi", "data/emoji-yomi.tsv",
+		"-o", out)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("gen_emoji.go: %v\n%s", err, b)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != emojiData {
+		t.Error("data/emoji.tsv is out of date; run go generate")
+	}
+}
@@ -0,0 +1,158 @@
+package dict
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// 文字コードの判定に使う先頭部分の大きさ。判定に使った部分も読み込み対象に残る
+const encodingSampleSize = 1 << 20
+
+// Emacs のファイル変数による文字コードの指定 (例: ;; -*- coding: euc-jp -*-)
+var reEmacsCoding = regexp.MustCompile(`-\*-.*\bcoding:\s*([A-Za-z0-9_.-]+)`)
+
+// LookupEncoding は文字コードの名前から encoding.Encoding を返す。Emacs の coding system の名前も使える
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	n := strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+	for _, eol := range []string{"-unix", "-dos", "-mac"} {
+		n = strings.TrimSuffix(n, eol)
+	}
+
+	switch n {
+	case "euc-jp", "eucjp", "euc-japan", "japanese-iso-8bit", "euc-jisx0213":
+		return japanese.EUCJP, nil
+	case "utf-8", "utf8", "mule-utf-8", "prefer-utf-8", "":
+		return unicode.UTF8, nil
+	case "shift-jis", "sjis", "cp932", "japanese-shift-jis", "windows-31j", "japanese-cp932":
+		return japanese.ShiftJIS, nil
+	case "iso-2022-jp", "junet", "iso-2022-7bit", "jis":
+		return japanese.ISO2022JP, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding: %s", name)
+}
+
+// EncodingName は SKK 辞書の coding に書く文字コードの名前を返す
+func EncodingName(enc encoding.Encoding) string {
+	switch enc {
+	case japanese.EUCJP:
+		return "euc-jp"
+	case japanese.ShiftJIS:
+		return "shift_jis"
+	case japanese.ISO2022JP:
+		return "iso-2022-jp"
+	}
+	return "utf-8"
+}
+
+func isEUCJP(data []byte) bool {
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c < 0x80:
+		case c == 0x8E:
+			// SS2: 半角カナ
+			if i+1 >= len(data) || data[i+1] < 0xA1 || data[i+1] > 0xDF {
+				return false
+			}
+			i++
+		case c == 0x8F:
+			// SS3: JIS X 0212
+			if i+2 >= len(data) || data[i+1] < 0xA1 || data[i+1] > 0xFE || data[i+2] < 0xA1 || data[i+2] > 0xFE {
+				return false
+			}
+			i += 2
+		case c >= 0xA1 && c <= 0xFE:
+			if i+1 >= len(data) || data[i+1] < 0xA1 || data[i+1] > 0xFE {
+				return false
+			}
+			i++
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isShiftJIS(data []byte) bool {
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c < 0x80, c >= 0xA1 && c <= 0xDF:
+			// ASCII と半角カナ
+		case c >= 0x81 && c <= 0x9F, c >= 0xE0 && c <= 0xFC:
+			if i+1 >= len(data) {
+				return false
+			}
+			t := data[i+1]
+			if t < 0x40 || t == 0x7F || t > 0xFC {
+				return false
+			}
+			i++
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isISO2022JP は JIS X 0208 への切り替えのエスケープシーケンスを含むかどうかを返す
+func isISO2022JP(data []byte) bool {
+	return bytes.Contains(data, []byte("\x1b$B")) || bytes.Contains(data, []byte("\x1b$@"))
+}
+
+// detectEncoding は先頭部分を読み進めずに覗いて文字コードを判定する。
+// Emacs の coding 指定、BOM、エスケープシーケンス、各文字コードとしての妥当性の順に判定する
+func detectEncoding(br *bufio.Reader) encoding.Encoding {
+	sample, _ := br.Peek(encodingSampleSize)
+
+	if bytes.HasPrefix(sample, []byte("\xef\xbb\xbf")) {
+		return unicode.UTF8
+	}
+
+	// coding の指定は先頭の2行までに書く
+	head := sample
+	for i, n := 0, 0; i < len(head); i++ {
+		if head[i] == '\n' {
+			n++
+			if n == 2 {
+				head = head[:i]
+				break
+			}
+		}
+	}
+	if ms := reEmacsCoding.FindSubmatch(head); len(ms) > 1 {
+		if enc, err := LookupEncoding(string(ms[1])); err == nil {
+			return enc
+		}
+	}
+
+	if isISO2022JP(sample) {
+		return japanese.ISO2022JP
+	}
+
+	// 途中で切れた文字で判定を誤らないように最後の行は使わない
+	if len(sample) == encodingSampleSize {
+		if i := bytes.LastIndexByte(sample, '\n'); i > 0 {
+			sample = sample[:i]
+		}
+	}
+
+	switch {
+	case utf8.Valid(sample):
+		return unicode.UTF8
+	case isEUCJP(sample):
+		return japanese.EUCJP
+	case isShiftJIS(sample):
+		return japanese.ShiftJIS
+	}
+
+	return unicode.UTF8
+}
@@ -0,0 +1,124 @@
+package dict
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// testdata/encoding の辞書は全て同じ内容を文字コードを変えて保存したもの
+var encodingFixtureEntries = []*Entry{
+	{Label: "かえr", Words: []Word{{Text: "帰"}, {Text: "返"}}},
+	{Label: "かんじ", Words: []Word{{Text: "漢字"}, {Text: "幹事", Desc: "組織の世話役"}}},
+	{Label: "にほん", Words: []Word{{Text: "日本"}, {Text: "二本"}}},
+	{Label: "ひょう", Words: []Word{{Text: "表"}, {Text: "票"}}},
+}
+
+func readEncodingFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "encoding", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		file string
+		want encoding.Encoding
+	}{
+		{"euc-jp.txt", japanese.EUCJP},
+		{"shift_jis.txt", japanese.ShiftJIS},
+		{"utf-8.txt", unicode.UTF8},
+		{"utf-8-bom.txt", unicode.UTF8},
+		{"iso-2022-jp.txt", japanese.ISO2022JP},
+	}
+
+	for _, tt := range tests {
+		b := readEncodingFixture(t, tt.file)
+		if got := detectEncoding(bufio.NewReader(bytes.NewReader(b))); got != tt.want {
+			t.Errorf("%s: detectEncoding() = %s, want %s", tt.file, EncodingName(got), EncodingName(tt.want))
+		}
+
+		r, err := NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		es, err := r.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.file, err)
+		}
+		if !reflect.DeepEqual(es, encodingFixtureEntries) {
+			t.Errorf("%s: ReadAll() = %v, want %v", tt.file, es, encodingFixtureEntries)
+		}
+	}
+}
+
+func TestDetectEncodingCodingHeader(t *testing.T) {
+	// 内容は EUC-JP としても正しいが coding の指定を優先する
+	src, err := japanese.ShiftJIS.NewEncoder().String(";; -*- mode: fundamental; coding: cp932 -*-\nｱｲ /ｱｲ/\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := detectEncoding(bufio.NewReader(strings.NewReader(src))); got != japanese.ShiftJIS {
+		t.Errorf("detectEncoding() = %s, want shift_jis", EncodingName(got))
+	}
+}
+
+func TestDetectEncodingSampleBoundary(t *testing.T) {
+	for _, enc := range []encoding.Encoding{unicode.UTF8, japanese.EUCJP, japanese.ShiftJIS} {
+		name := EncodingName(enc)
+		word, err := enc.NewEncoder().String("漢字")
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, err := enc.NewEncoder().String("かんじ /漢字/\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 最後の行の「漢字」の1バイト目が encodingSampleSize の直前に来るように ASCII の行で調整する
+		var b bytes.Buffer
+		b.WriteString(first)
+		lines := 1
+		for b.Len() < encodingSampleSize-4096 {
+			b.WriteString("ascii /" + strings.Repeat("x", 100) + "/\n")
+			lines++
+		}
+		label := "a /"
+		pad := encodingSampleSize - b.Len() - len(label) - 1
+		b.WriteString(label + strings.Repeat("x", pad) + word + "/\n")
+		lines++
+		if b.Len() <= encodingSampleSize || b.Bytes()[encodingSampleSize-1] != word[0] {
+			t.Fatalf("%s: multibyte character does not cross the sample boundary", name)
+		}
+
+		if got := detectEncoding(bufio.NewReaderSize(bytes.NewReader(b.Bytes()), encodingSampleSize)); got != enc {
+			t.Errorf("%s: detectEncoding() = %s", name, EncodingName(got))
+		}
+
+		r, err := NewReader(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		es, err := r.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(es) != lines {
+			t.Fatalf("%s: %d entries, want %d", name, len(es), lines)
+		}
+		if got, want := es[len(es)-1].Words[0].Text, strings.Repeat("x", pad)+"漢字"; got != want {
+			t.Errorf("%s: last candidate = %q, want %q", name, got[len(got)-10:], want[len(want)-10:])
+		}
+	}
+}
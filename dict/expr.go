@@ -0,0 +1,60 @@
+package dict
+
+// MergeEntries は複数の辞書のエントリをまとめる。
+// 同じ見出し語の候補は引数の順番に並べ、同じ候補は最初のものだけを残す
+func MergeEntries(lists ...[]*Entry) []*Entry {
+	merged := []*Entry{}
+	byLabel := map[string]*Entry{}
+	seen := map[string]map[string]bool{}
+
+	for _, es := range lists {
+		for _, e := range es {
+			me, ok := byLabel[e.Label]
+			if !ok {
+				me = &Entry{Label: e.Label}
+				byLabel[e.Label] = me
+				seen[e.Label] = map[string]bool{}
+				merged = append(merged, me)
+			}
+			for _, w := range e.Words {
+				if seen[e.Label][w.Text] {
+					continue
+				}
+				seen[e.Label][w.Text] = true
+				me.Words = append(me.Words, w)
+			}
+		}
+	}
+
+	return merged
+}
+
+// SubtractEntries は base のエントリから others に含まれる候補を取り除く。候補がなくなった見出し語は削除する
+func SubtractEntries(base []*Entry, others ...[]*Entry) []*Entry {
+	remove := map[string]map[string]bool{}
+	for _, es := range others {
+		for _, e := range es {
+			if remove[e.Label] == nil {
+				remove[e.Label] = map[string]bool{}
+			}
+			for _, w := range e.Words {
+				remove[e.Label][w.Text] = true
+			}
+		}
+	}
+
+	result := []*Entry{}
+	for _, e := range MergeEntries(base) {
+		ws := []Word{}
+		for _, w := range e.Words {
+			if !remove[e.Label][w.Text] {
+				ws = append(ws, w)
+			}
+		}
+		if len(ws) > 0 {
+			result = append(result, &Entry{Label: e.Label, Words: ws})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,228 @@
+//go:build ignore
+
+// data/emoji.tsv を Unicode CLDR の annotations から生成する。
+// 入力は data/cldr (cldr-annotations-full の annotations.json)、data/emoji-data.txt、
+// data/gemoji.json と、漢字のキーワードの読みを書いた data/emoji-yomi.tsv
+//
+//	go run gen_emoji.go -ja data/cldr/annotations/ja/annotations.json \
+//	    -en data/cldr/annotations/en/annotations.json -emoji-data data/emoji-data.txt \
+//	    -gemoji data/gemoji.json -yomi data/emoji-yomi.tsv -o data/emoji.tsv
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type annotations struct {
+	Annotations struct {
+		Annotations map[string]struct {
+			Default []string `json:"default"`
+			TTS     []string `json:"tts"`
+		} `json:"annotations"`
+	} `json:"annotations"`
+}
+
+type gemoji struct {
+	Emoji   string   `json:"emoji"`
+	Aliases []string `json:"aliases"`
+}
+
+func loadJSON(path string, v interface{}) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadModifierBases は emoji-data.txt から Emoji_Modifier_Base の文字を読み込む
+func loadModifierBases(path string) map[rune]bool {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	bases := map[rune]bool{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		cp, prop, ok := strings.Cut(line, ";")
+		if !ok || strings.TrimSpace(prop) != "Emoji_Modifier_Base" {
+			continue
+		}
+		from, to, ok := strings.Cut(strings.TrimSpace(cp), "..")
+		if !ok {
+			to = from
+		}
+		s, err := strconv.ParseInt(from, 16, 32)
+		if err != nil {
+			log.Fatal(err)
+		}
+		e, err := strconv.ParseInt(to, 16, 32)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for r := s; r <= e; r++ {
+			bases[rune(r)] = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return bases
+}
+
+// loadYomi は「キーワード<TAB>読み(空白区切り)」の形式のファイルを読み込む
+func loadYomi(path string) map[string][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	yomi := map[string][]string{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kw, rs, ok := strings.Cut(line, "\t")
+		if !ok {
+			log.Fatalf("invalid yomi: %s", line)
+		}
+		yomi[kw] = strings.Fields(rs)
+	}
+	if err := sc.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return yomi
+}
+
+// toReading はかなだけで構成されたキーワードをひらがなの読みに変換する
+func toReading(s string) (string, bool) {
+	rs := []rune(s)
+	for i, r := range rs {
+		switch {
+		case r >= 'ぁ' && r <= 'ゖ', r == 'ー':
+		case r >= 'ァ' && r <= 'ヶ':
+			rs[i] = r - 0x60
+		default:
+			return "", false
+		}
+	}
+	return string(rs), len(rs) > 0
+}
+
+func toShortcode(name string) string {
+	name = strings.ToLower(name)
+	name = strings.NewReplacer(" ", "_", "-", "_", ":", "", ",", "", "’", "", ".", "").Replace(name)
+	return ":" + name + ":"
+}
+
+func main() {
+	jaPath := flag.String("ja", "", "CLDR annotations.json (ja)")
+	enPath := flag.String("en", "", "CLDR annotations.json (en)")
+	dataPath := flag.String("emoji-data", "", "emoji-data.txt")
+	gemojiPath := flag.String("gemoji", "", "gemoji emoji.json (optional)")
+	yomiPath := flag.String("yomi", "", "readings of kanji keywords (optional)")
+	out := flag.String("o", "data/emoji.tsv", "output file")
+	flag.Parse()
+
+	var ja, en annotations
+	loadJSON(*jaPath, &ja)
+	loadJSON(*enPath, &en)
+	bases := loadModifierBases(*dataPath)
+
+	yomi := map[string][]string{}
+	if *yomiPath != "" {
+		yomi = loadYomi(*yomiPath)
+	}
+
+	aliases := map[string][]string{}
+	if *gemojiPath != "" {
+		var gs []gemoji
+		loadJSON(*gemojiPath, &gs)
+		for _, g := range gs {
+			aliases[g.Emoji] = g.Aliases
+		}
+	}
+
+	keys := make([]string, 0, len(ja.Annotations.Annotations))
+	for k := range ja.Annotations.Annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "# Bragi emoji/symbol dictionary")
+	fmt.Fprintln(w, "#")
+	fmt.Fprintln(w, "# gen_emoji.go によって Unicode CLDR の annotations (ja, en) と emoji-data.txt から生成")
+	fmt.Fprintln(w, "# 読みはかなのキーワードと emoji-yomi.tsv、ショートコードは CLDR の英語名と gemoji のエイリアスから作成")
+	fmt.Fprintln(w, "#")
+	fmt.Fprintln(w, "# 絵文字\t肌の色の変更可否\t注釈\t読み(空白区切り)\tショートコード(空白区切り)")
+
+	for _, k := range keys {
+		a := ja.Annotations.Annotations[k]
+		if len(a.TTS) == 0 {
+			continue
+		}
+
+		readings := []string{}
+		seen := map[string]bool{}
+		for _, kw := range a.Default {
+			rs := yomi[kw]
+			if r, ok := toReading(kw); ok {
+				rs = []string{r}
+			}
+			for _, r := range rs {
+				if !seen[r] {
+					seen[r] = true
+					readings = append(readings, r)
+				}
+			}
+		}
+
+		shortcodes := []string{}
+		if e, ok := en.Annotations.Annotations[k]; ok && len(e.TTS) > 0 {
+			shortcodes = append(shortcodes, toShortcode(e.TTS[0]))
+		}
+		for _, al := range aliases[k] {
+			if sc := ":" + al + ":"; len(shortcodes) == 0 || sc != shortcodes[0] {
+				shortcodes = append(shortcodes, sc)
+			}
+		}
+		if len(readings) == 0 && len(shortcodes) == 0 {
+			continue
+		}
+
+		tone := "0"
+		if bases[[]rune(k)[0]] {
+			tone = "1"
+		}
+
+		desc := strings.NewReplacer("/", "／", ";", "；").Replace(a.TTS[0])
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k, tone, desc, strings.Join(readings, " "), strings.Join(shortcodes, " "))
+	}
+}
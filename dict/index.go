@@ -0,0 +1,351 @@
+package dict
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Store は見出し語から候補を引くための辞書の格納方式
+type Store interface {
+	// Lookup は見出し語に完全一致する候補を返す。格納したデータが壊れている場合はエラーを返す
+	Lookup(label string) ([]Word, bool, error)
+	// Prefix は prefix で始まる見出し語を辞書順に fn に渡す。fn が false を返すと中断する
+	Prefix(prefix string, fn func(label string, words []Word) bool)
+	// Len は見出し語の数を返す
+	Len() int
+}
+
+func (m DicMap) Lookup(label string) ([]Word, bool, error) {
+	ws, ok := m[label]
+	return ws, ok, nil
+}
+
+func (m DicMap) Prefix(prefix string, fn func(label string, words []Word) bool) {
+	labels := []string{}
+	for label := range m {
+		if strings.HasPrefix(label, prefix) {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		if !fn(label, m[label]) {
+			return
+		}
+	}
+}
+
+func (m DicMap) Len() int {
+	return len(m)
+}
+
+const (
+	indexMagic = "BRGIDX02"
+
+	// 1つのエントリは「見出し語 \x00 候補 \x1f 注釈 \x1e 候補 \x1f 注釈 ... \n」の形式で格納する
+	indexLabelSep = '\x00'
+	indexWordSep  = '\x1e'
+	indexDescSep  = '\x1f'
+	indexEntryEnd = '\n'
+)
+
+// Index は見出し語でソートしたオフセット表と、全エントリを格納した1つのバイト列からなる辞書の索引。
+// Word の構造体や文字列をエントリごとに持たないため DicMap よりメモリ使用量が少ない。
+// 完全一致はハッシュ表、前方一致はソートしたオフセット表で検索する
+type Index struct {
+	// エントリの先頭位置(リトルエンディアンの uint32)を見出し語の順に並べたもの
+	offsets []byte
+	data    []byte
+	// 見出し語のハッシュによるオープンアドレス法のハッシュ表。
+	// 各スロットは offsets の位置+1(0は空き)を uint32 で持つ。空の場合は二分探索する
+	slots []byte
+	// mmap した場合の解放処理
+	closer func() error
+}
+
+func (idx *Index) Len() int {
+	return len(idx.offsets) / 4
+}
+
+func (idx *Index) offset(i int) int {
+	return int(binary.LittleEndian.Uint32(idx.offsets[i*4:]))
+}
+
+// record は i 番目のエントリの見出し語と候補の部分を返す。
+// 開くときに全てのエントリは検査しないため、読むときに範囲と形式を確かめる
+func (idx *Index) record(i int) (label, body []byte, err error) {
+	if i < 0 || i >= idx.Len() {
+		return nil, nil, fmt.Errorf("index entry %d is out of range", i)
+	}
+	o := idx.offset(i)
+	if o >= len(idx.data) {
+		return nil, nil, fmt.Errorf("index entry %d has invalid offset: %d", i, o)
+	}
+	rec := idx.data[o:]
+	end := bytes.IndexByte(rec, indexEntryEnd)
+	if end < 0 {
+		return nil, nil, fmt.Errorf("index entry %d is not terminated", i)
+	}
+	label, body, ok := bytes.Cut(rec[:end], []byte{indexLabelSep})
+	if !ok {
+		return nil, nil, fmt.Errorf("index entry %d has no label separator", i)
+	}
+	return label, body, nil
+}
+
+// label は i 番目のエントリの見出し語を返す。壊れている場合は nil を返す
+func (idx *Index) label(i int) []byte {
+	label, _, _ := idx.record(i)
+	return label
+}
+
+func parseIndexWords(body []byte) []Word {
+	ws := []Word{}
+	for _, w := range bytes.Split(body, []byte{indexWordSep}) {
+		text, desc, _ := bytes.Cut(w, []byte{indexDescSep})
+		ws = append(ws, Word{Text: string(text), Desc: string(desc)})
+	}
+	return ws
+}
+
+// search は label 以上となる最初の見出し語の位置を返す
+func (idx *Index) search(label string) int {
+	return sort.Search(idx.Len(), func(i int) bool {
+		return string(idx.label(i)) >= label
+	})
+}
+
+func (idx *Index) nslots() int {
+	return len(idx.slots) / 4
+}
+
+func (idx *Index) slot(i int) int {
+	return int(binary.LittleEndian.Uint32(idx.slots[i*4:]))
+}
+
+func hashLabel(label string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(label))
+	return h.Sum32()
+}
+
+func (idx *Index) Lookup(label string) ([]Word, bool, error) {
+	if n := idx.nslots(); n > 0 {
+		// ハッシュ表で定数時間で検索する。壊れたファイルで空きがない場合も一周したら止める
+		i := int(hashLabel(label) % uint32(n))
+		for probe := 0; probe < n; probe, i = probe+1, (i+1)%n {
+			e := idx.slot(i)
+			if e == 0 {
+				return nil, false, nil
+			}
+			l, body, err := idx.record(e - 1)
+			if err != nil {
+				return nil, false, err
+			}
+			if string(l) == label {
+				return parseIndexWords(body), true, nil
+			}
+		}
+		return nil, false, nil
+	}
+
+	i := idx.search(label)
+	if i >= idx.Len() {
+		return nil, false, nil
+	}
+	l, body, err := idx.record(i)
+	if err != nil {
+		return nil, false, err
+	}
+	if string(l) != label {
+		return nil, false, nil
+	}
+	return parseIndexWords(body), true, nil
+}
+
+// Prefix は prefix で始まる見出し語を辞書順に fn に渡す。壊れたエントリに達した場合はそこで止める
+func (idx *Index) Prefix(prefix string, fn func(label string, words []Word) bool) {
+	for i := idx.search(prefix); i < idx.Len(); i++ {
+		label, body, err := idx.record(i)
+		if err != nil || !strings.HasPrefix(string(label), prefix) {
+			return
+		}
+		if !fn(string(label), parseIndexWords(body)) {
+			return
+		}
+	}
+}
+
+// Close は mmap した索引を解放する
+func (idx *Index) Close() error {
+	if idx.closer == nil {
+		return nil
+	}
+	closer := idx.closer
+	idx.closer = nil
+	idx.offsets, idx.data, idx.slots = nil, nil, nil
+	return closer()
+}
+
+const indexHeaderSize = len(indexMagic) + 12
+
+// WriteTo は索引をファイルに保存できる形式で書き出す
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, indexHeaderSize)
+	copy(header, indexMagic)
+	binary.LittleEndian.PutUint32(header[len(indexMagic):], uint32(idx.Len()))
+	binary.LittleEndian.PutUint32(header[len(indexMagic)+4:], uint32(len(idx.data)))
+	binary.LittleEndian.PutUint32(header[len(indexMagic)+8:], uint32(idx.nslots()))
+
+	var n int64
+	for _, b := range [][]byte{header, idx.offsets, idx.data, idx.slots} {
+		m, err := w.Write(b)
+		n += int64(m)
+		if err != nil {
+			return n, errors.WithStack(err)
+		}
+	}
+	return n, nil
+}
+
+// parseIndex は WriteTo で書き出した形式のバイト列から索引を作る。buf はコピーせずに参照する。
+// 起動を速くするためにヘッダーと各部分の大きさだけを確かめ、エントリは読むときに検査する。
+// 索引の後ろの位置も返す
+func parseIndex(buf []byte) (*Index, int, error) {
+	if len(buf) < indexHeaderSize || string(buf[:len(indexMagic)]) != indexMagic {
+		return nil, 0, fmt.Errorf("invalid index format")
+	}
+	count := int(binary.LittleEndian.Uint32(buf[len(indexMagic):]))
+	size := int(binary.LittleEndian.Uint32(buf[len(indexMagic)+4:]))
+	nslots := int(binary.LittleEndian.Uint32(buf[len(indexMagic)+8:]))
+
+	p := indexHeaderSize
+	end := p + count*4 + size + nslots*4
+	if len(buf) < end {
+		return nil, 0, fmt.Errorf("index is truncated")
+	}
+	// ハッシュ表は見出し語より多くのスロットを持つ
+	if nslots > 0 && nslots <= count {
+		return nil, 0, fmt.Errorf("index hash table is too small: %d slots for %d entries", nslots, count)
+	}
+	idx := &Index{}
+	idx.offsets, p = buf[p:p+count*4], p+count*4
+	idx.data, p = buf[p:p+size], p+size
+	idx.slots = buf[p:end]
+
+	return idx, end, nil
+}
+
+// OpenIndex は WriteTo で保存した索引ファイルを開く。可能な場合は mmap して読み込む
+func OpenIndex(path string) (*Index, error) {
+	buf, closer, err := mapFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	idx, _, err := parseIndex(buf)
+	if err != nil {
+		closer()
+		return nil, errors.Wrap(err, path)
+	}
+	idx.closer = closer
+
+	return idx, nil
+}
+
+// stripIndexSep は区切り文字として使う制御文字を取り除く
+func stripIndexSep(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case indexLabelSep, indexWordSep, indexDescSep, indexEntryEnd:
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// IndexBuilder はエントリを1つずつ追加して Index を作る
+type IndexBuilder struct {
+	offsets []uint32
+	labels  []string
+	data    []byte
+}
+
+func (b *IndexBuilder) Add(e *Entry) {
+	label := stripIndexSep(e.Label)
+	b.offsets = append(b.offsets, uint32(len(b.data)))
+	b.labels = append(b.labels, label)
+
+	b.data = append(b.data, label...)
+	b.data = append(b.data, indexLabelSep)
+	for i, w := range e.Words {
+		if i > 0 {
+			b.data = append(b.data, indexWordSep)
+		}
+		b.data = append(b.data, stripIndexSep(w.Text)...)
+		b.data = append(b.data, indexDescSep)
+		b.data = append(b.data, stripIndexSep(w.Desc)...)
+	}
+	b.data = append(b.data, indexEntryEnd)
+}
+
+// Build は見出し語でソートした索引を作る。同じ見出し語が複数ある場合は DicMap と同じく後のものを使う
+func (b *IndexBuilder) Build() *Index {
+	order := make([]int, len(b.offsets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return b.labels[order[i]] < b.labels[order[j]]
+	})
+
+	offsets := make([]byte, 0, len(order)*4)
+	labels := []string{}
+	for i, o := range order {
+		if i+1 < len(order) && b.labels[order[i+1]] == b.labels[o] {
+			continue
+		}
+		offsets = binary.LittleEndian.AppendUint32(offsets, b.offsets[o])
+		labels = append(labels, b.labels[o])
+	}
+
+	// 負荷率が0.5になるようにハッシュ表を作る
+	n := len(labels) * 2
+	slots := make([]byte, n*4)
+	for i, label := range labels {
+		j := int(hashLabel(label) % uint32(n))
+		for binary.LittleEndian.Uint32(slots[j*4:]) != 0 {
+			j = (j + 1) % n
+		}
+		binary.LittleEndian.PutUint32(slots[j*4:], uint32(i+1))
+	}
+
+	return &Index{offsets: offsets, data: b.data, slots: slots}
+}
+
+// NewIndex は DicMap から索引を作る
+func NewIndex(m DicMap) *Index {
+	b := &IndexBuilder{}
+	for label, ws := range m {
+		b.Add(&Entry{Label: label, Words: ws})
+	}
+	return b.Build()
+}
+
+// readFile は mmap できない環境で索引ファイルを読み込む
+func readFile(path string) ([]byte, func() error, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return buf, func() error { return nil }, nil
+}
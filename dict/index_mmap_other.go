@@ -0,0 +1,8 @@
+//go:build !unix
+
+package dict
+
+// mapFile は mmap できない環境ではファイルを読み込む
+func mapFile(path string) ([]byte, func() error, error) {
+	return readFile(path)
+}
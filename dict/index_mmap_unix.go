@@ -0,0 +1,34 @@
+//go:build unix
+
+package dict
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// mapFile はファイルを読み取り専用で mmap する
+func mapFile(path string) ([]byte, func() error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if fi.Size() == 0 {
+		return readFile(path)
+	}
+
+	buf, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	return buf, func() error { return syscall.Munmap(buf) }, nil
+}
@@ -0,0 +1,275 @@
+package dict
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+// benchDicMap はベンチマーク用に多数の見出し語を持つ辞書を作る
+func benchDicMap(n int) DicMap {
+	m := DicMap{}
+	for i := 0; i < n; i++ {
+		label := fmt.Sprintf("みだし%d", i)
+		m[label] = []Word{{Text: fmt.Sprintf("見出%d", i), Desc: "注釈"}, {Text: fmt.Sprintf("候補%d", i)}}
+	}
+	return m
+}
+
+func benchLabels(m DicMap) []string {
+	labels := make([]string, 0, len(m))
+	for label := range m {
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+type benchStore struct {
+	name string
+	s    Store
+}
+
+func benchStores(m DicMap) []benchStore {
+	return []benchStore{{"DicMap", m}, {"Index", NewIndex(m)}}
+}
+
+func TestIndexLookup(t *testing.T) {
+	m := benchDicMap(100)
+	idx := NewIndex(m)
+	if idx.Len() != len(m) {
+		t.Fatalf("Len() = %d, want %d", idx.Len(), len(m))
+	}
+	for label, want := range m {
+		got, ok, err := idx.Lookup(label)
+		if err != nil || !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Lookup(%q) = %v, %v, %v, want %v", label, got, ok, err, want)
+		}
+	}
+	if _, ok, err := idx.Lookup("ない"); ok || err != nil {
+		t.Errorf("Lookup(%q) found", "ない")
+	}
+}
+
+func TestIndexWriteTo(t *testing.T) {
+	m := benchDicMap(100)
+	var buf bytes.Buffer
+	if _, err := NewIndex(m).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	idx, _, err := parseIndex(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for label, want := range m {
+		got, ok, err := idx.Lookup(label)
+		if err != nil || !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Lookup(%q) = %v, %v, %v, want %v", label, got, ok, err, want)
+		}
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	m := benchDicMap(100000)
+	labels := benchLabels(m)
+	for _, bs := range benchStores(m) {
+		s := bs.s
+		b.Run(bs.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, ok, _ := s.Lookup(labels[i%len(labels)]); !ok {
+					b.Fatal("not found")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPrefix(b *testing.B) {
+	m := benchDicMap(100000)
+	for _, bs := range benchStores(m) {
+		s := bs.s
+		b.Run(bs.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				n := 0
+				s.Prefix("みだし1", func(string, []Word) bool {
+					n++
+					return n < 10
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkLoad(b *testing.B) {
+	// 辞書ファイルの読み込みから格納までを計測する
+	var src bytes.Buffer
+	for label, ws := range benchDicMap(100000) {
+		src.WriteString(label + " /")
+		for _, w := range ws {
+			src.WriteString(w.String() + "/")
+		}
+		src.WriteString("\n")
+	}
+
+	for _, compact := range []bool{false, true} {
+		name := "DicMap"
+		if compact {
+			name = "Index"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r, err := NewReaderWithEncoding(bytes.NewReader(src.Bytes()), unicode.UTF8)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := readSkkDict(r, &SkkDictOptions{Compact: compact}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseIndexBroken(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewIndex(benchDicMap(10)).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	count := 10
+
+	// 開くときはヘッダーと各部分の大きさだけを確かめる
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+	}{
+		{"magic", func(b []byte) []byte {
+			b[0] = 'x'
+			return b
+		}},
+		{"truncated", func(b []byte) []byte {
+			return b[:len(b)-1]
+		}},
+		{"data size", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[len(indexMagic)+4:], uint32(len(b)))
+			return b
+		}},
+		{"hash table too small", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[len(indexMagic)+8:], uint32(count))
+			return b
+		}},
+	}
+	for _, tt := range tests {
+		b := tt.modify(append([]byte{}, valid...))
+		if _, _, err := parseIndex(b); err == nil {
+			t.Errorf("%s: parseIndex succeeded", tt.name)
+		}
+	}
+
+	if _, _, err := parseIndex(valid); err != nil {
+		t.Errorf("parseIndex(valid) = %v", err)
+	}
+}
+
+// TestIndexBrokenEntry は壊れたエントリを検索したときにエラーを返すことを確かめる
+func TestIndexBrokenEntry(t *testing.T) {
+	m := benchDicMap(10)
+	var buf bytes.Buffer
+	if _, err := NewIndex(m).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	count := len(m)
+	dataStart := indexHeaderSize + count*4
+	size := int(binary.LittleEndian.Uint32(valid[len(indexMagic)+4:]))
+	slotStart := dataStart + size
+
+	// slots だけが壊れている場合は前方一致の検索には影響しない
+	tests := []struct {
+		name         string
+		brokenRecord bool
+		modify       func(b []byte)
+	}{
+		{"offset out of range", true, func(b []byte) {
+			for i := indexHeaderSize; i < dataStart; i += 4 {
+				binary.LittleEndian.PutUint32(b[i:], uint32(size))
+			}
+		}},
+		{"missing label separator", true, func(b []byte) {
+			for i := dataStart; i < slotStart; i++ {
+				if b[i] == indexLabelSep {
+					b[i] = 'x'
+				}
+			}
+		}},
+		{"missing entry terminator", true, func(b []byte) {
+			for i := dataStart; i < slotStart; i++ {
+				if b[i] == indexEntryEnd {
+					b[i] = 'x'
+				}
+			}
+		}},
+		{"slot out of range", false, func(b []byte) {
+			for i := slotStart; i < len(b); i += 4 {
+				if binary.LittleEndian.Uint32(b[i:]) != 0 {
+					binary.LittleEndian.PutUint32(b[i:], uint32(count+1))
+				}
+			}
+		}},
+	}
+	for _, tt := range tests {
+		b := append([]byte{}, valid...)
+		tt.modify(b)
+		idx, _, err := parseIndex(b)
+		if err != nil {
+			t.Fatalf("%s: parseIndex = %v", tt.name, err)
+		}
+		for label := range m {
+			if _, _, err := idx.Lookup(label); err == nil {
+				t.Errorf("%s: Lookup(%q) succeeded", tt.name, label)
+			}
+		}
+		n := 0
+		idx.Prefix("", func(string, []Word) bool {
+			n++
+			return true
+		})
+		if tt.brokenRecord && n != 0 || !tt.brokenRecord && n != count {
+			t.Errorf("%s: Prefix returned %d entries", tt.name, n)
+		}
+	}
+}
+
+// TestIndexFullHashTable は空きのないハッシュ表で見つからない見出し語の検索が終わることを確かめる
+func TestIndexFullHashTable(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewIndex(benchDicMap(10)).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	idx, _, err := parseIndex(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < idx.nslots(); i++ {
+		binary.LittleEndian.PutUint32(idx.slots[i*4:], 1)
+	}
+	if _, ok, err := idx.Lookup("ない"); ok || err != nil {
+		t.Errorf("Lookup(%q) = %v, %v", "ない", ok, err)
+	}
+}
+
+func TestIndexOutOfRange(t *testing.T) {
+	idx := NewIndex(benchDicMap(3))
+	if l := idx.label(-1); l != nil {
+		t.Errorf("label(-1) = %q", l)
+	}
+	if _, _, err := idx.record(idx.Len()); err == nil {
+		t.Error("record(Len()) succeeded")
+	}
+}
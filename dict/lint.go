@@ -0,0 +1,135 @@
+package dict
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// 辞書の読み込み時の警告の種類
+const (
+	WarnMalformed = "malformed"
+	WarnDuplicate = "duplicate"
+	WarnEmpty     = "empty_candidate"
+	WarnConcat    = "broken_concat"
+	WarnSection   = "section_order"
+)
+
+// SkkDict に保持する警告の最大数
+const maxDictWarnings = 1000
+
+const (
+	okuriAriHeader  = ";; okuri-ari entries."
+	okuriNasiHeader = ";; okuri-nasi entries."
+)
+
+// Warning は辞書の読み込み時に見つかった問題
+type Warning struct {
+	Line    int    `json:"line"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%d: %s: %s", w.Line, w.Kind, w.Message)
+}
+
+// isBrokenConcat は (concat "...") の形式として正しくない候補かどうかを返す
+func isBrokenConcat(s string) bool {
+	if !strings.Contains(s, "(concat") {
+		return false
+	}
+	loc := concatPattern.FindStringIndex(s)
+	return loc == nil || loc[0] != 0 || loc[1] != len(s)
+}
+
+// linter は1行ずつ辞書の問題を調べる
+type linter struct {
+	warnings []Warning
+	labels   map[string]int
+	section  string
+}
+
+func (l *linter) warn(line int, kind, format string, args ...interface{}) {
+	l.warnings = append(l.warnings, Warning{Line: line, Kind: kind, Message: fmt.Sprintf(format, args...)})
+}
+
+// check は行を調べ、読み込める場合はエントリを返す
+func (l *linter) check(line int, text string) *Entry {
+	switch strings.TrimSpace(text) {
+	case okuriAriHeader:
+		if l.section == okuriNasiHeader {
+			l.warn(line, WarnSection, "okuri-ari section after okuri-nasi section")
+		}
+		l.section = okuriAriHeader
+		return nil
+	case okuriNasiHeader:
+		l.section = okuriNasiHeader
+		return nil
+	}
+
+	e, err := parseLine(text)
+	if err != nil {
+		l.warn(line, WarnMalformed, "%s", text)
+		return nil
+	}
+	if e == nil {
+		return nil
+	}
+
+	if prev, ok := l.labels[e.Label]; ok {
+		l.warn(line, WarnDuplicate, "%s (first defined at line %d)", e.Label, prev)
+	} else {
+		l.labels[e.Label] = line
+	}
+
+	switch {
+	case l.section == okuriNasiHeader && isOkuriAri(e.Label):
+		l.warn(line, WarnSection, "okuri-ari entry in okuri-nasi section: %s", e.Label)
+	case l.section == okuriAriHeader && !isOkuriAri(e.Label):
+		l.warn(line, WarnSection, "okuri-nasi entry in okuri-ari section: %s", e.Label)
+	}
+
+	_, raw, _ := strings.Cut(text, " ")
+	for _, c := range strings.Split(strings.Trim(raw, "/"), "/") {
+		for _, s := range strings.Split(c, ";") {
+			if isBrokenConcat(s) {
+				l.warn(line, WarnConcat, "%s: %s", e.Label, s)
+			}
+		}
+	}
+
+	ws := []Word{}
+	for _, w := range e.Words {
+		if w.Text == "" {
+			l.warn(line, WarnEmpty, "%s", e.Label)
+			continue
+		}
+		ws = append(ws, w)
+	}
+	if len(ws) == 0 {
+		return nil
+	}
+	e.Words = ws
+
+	return e
+}
+
+// ReadLenient は全ての行を読み込み、読み込めたエントリを fn に渡す。
+// 形式の誤りや重複などの問題は読み込みを止めずに行番号付きの警告として返す
+func (r *Reader) ReadLenient(fn func(e *Entry)) ([]Warning, error) {
+	l := &linter{labels: map[string]int{}}
+
+	for r.scanner.Scan() {
+		r.line++
+		if e := l.check(r.line, r.scanner.Text()); e != nil {
+			fn(e)
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return l.warnings, errors.Wrapf(err, "line %d", r.line+1)
+	}
+
+	return l.warnings, nil
+}
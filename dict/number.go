@@ -0,0 +1,41 @@
+package dict
+
+import (
+	"regexp"
+
+	"golang.org/x/text/width"
+)
+
+// 数値として受け付ける最大の桁数
+const maxNumberDigits = 48
+
+var reNumber = regexp.MustCompile(`[0-9]+`)
+
+// 数値辞書で返す SKK の数値変換の型の順番
+var numberTypes = []byte{'3', '2', '1', '8', '5'}
+
+type NumberDict struct{}
+
+func (d *NumberDict) Convert(word string) ([]string, error) {
+	num := width.Narrow.String(word)
+	if !isDigits(num) || len(num) > maxNumberDigits {
+		return []string{}, nil
+	}
+
+	words := []string{}
+	seen := map[string]bool{num: true}
+	for _, typ := range numberTypes {
+		s, ok := formatNumber(num, typ)
+		if !ok || seen[s] {
+			continue
+		}
+		seen[s] = true
+		words = append(words, s)
+	}
+
+	return words, nil
+}
+
+func NewNumberDict() *NumberDict {
+	return &NumberDict{}
+}
@@ -0,0 +1,65 @@
+package dict
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNumberDictConvert(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"0", []string{"〇", "０", "零"}},
+		// 位取りのない漢数字が位取りのある漢数字と同じ場合は1つにまとめる
+		{"7", []string{"七", "７"}},
+		{"10", []string{"十", "一〇", "１０", "壱拾"}},
+		{"123", []string{"百二十三", "一二三", "１２３", "壱百弐拾参"}},
+		// 3桁ごとの区切り
+		{"1234", []string{"千二百三十四", "一二三四", "１２３４", "1,234", "壱千弐百参拾四"}},
+		{"10000", []string{"一万", "一〇〇〇〇", "１００００", "10,000", "壱萬"}},
+		{"12345", []string{"一万二千三百四十五", "一二三四五", "１２３４５", "12,345", "壱萬弐千参百四拾伍"}},
+		// 大きな数
+		{"100000000", []string{"一億", "一〇〇〇〇〇〇〇〇", "１００００００００", "100,000,000", "壱億"}},
+		{"1234567890123", []string{
+			"一兆二千三百四十五億六千七百八十九万百二十三",
+			"一二三四五六七八九〇一二三",
+			"１２３４５６７８９０１２３",
+			"1,234,567,890,123",
+			"壱兆弐千参百四拾伍億六千七百八拾九萬壱百弐拾参",
+		}},
+		// 全角数字
+		{"１２３", []string{"百二十三", "一二三", "１２３", "壱百弐拾参"}},
+		// 数値ではないもの
+		{"", []string{}},
+		{"abc", []string{}},
+		{"12a", []string{}},
+		{"-5", []string{}},
+		{"1.5", []string{}},
+		{"いち", []string{}},
+		{strings.Repeat("1", maxNumberDigits+1), []string{}},
+	}
+
+	d := NewNumberDict()
+	for _, tt := range tests {
+		got, err := d.Convert(tt.word)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Convert(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestNumberDictMaxDigits(t *testing.T) {
+	got, err := NewNumberDict().Convert(strings.Repeat("1", maxNumberDigits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 最大の桁数まで位取りした漢数字に変換できる
+	if len(got) == 0 || !strings.HasPrefix(got[0], "千百十一載") || !strings.HasSuffix(got[0], "万千百十一") {
+		t.Errorf("Convert(%d digits) = %q", maxNumberDigits, got)
+	}
+}
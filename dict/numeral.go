@@ -0,0 +1,203 @@
+package dict
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var kanjiDigits = []string{"〇", "一", "二", "三", "四", "五", "六", "七", "八", "九"}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// splitSign は数値文字列を符号・整数部・小数部に分割する
+func splitSign(s string) (sign, ip, fp string) {
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	ip, fp, _ = strings.Cut(s, ".")
+	return sign, ip, fp
+}
+
+// formatDecimal は小数点以下をdecimals桁までに丸め、末尾の0を取り除いた文字列を返す
+func formatDecimal(v float64, decimals int) string {
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+// groupDigits は数値文字列の整数部を3桁ごとにカンマで区切る
+func groupDigits(s string) string {
+	sign, ip, fp := splitSign(s)
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, c := range ip {
+		if i > 0 && (len(ip)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	if fp != "" {
+		b.WriteString("." + fp)
+	}
+
+	return b.String()
+}
+
+// 漢数字の表記
+type numeralStyle struct {
+	digits   []string
+	subUnits []string
+	units    []string
+	// 十・百・千の前の一を省略するか
+	omitOne bool
+}
+
+var kanjiStyle = &numeralStyle{
+	digits:   kanjiDigits,
+	subUnits: []string{"", "十", "百", "千"},
+	units:    []string{"", "万", "億", "兆", "京", "垓", "𥝱", "穣", "溝", "澗", "正", "載", "極"},
+	omitOne:  true,
+}
+
+// 大字(例: 壱萬弐千参百四拾伍)
+var daijiStyle = &numeralStyle{
+	digits:   []string{"零", "壱", "弐", "参", "四", "伍", "六", "七", "八", "九"},
+	subUnits: []string{"", "拾", "百", "千"},
+	units:    []string{"", "萬", "億", "兆", "京", "垓", "𥝱", "穣", "溝", "澗", "正", "載", "極"},
+	omitOne:  false,
+}
+
+// format は整数の数字列を位取りした漢数字に変換する。位が足りない場合は空文字を返す
+func (ns *numeralStyle) format(s string) string {
+	s = strings.TrimLeft(s, "0")
+	if s == "" {
+		return ns.digits[0]
+	}
+	if !isDigits(s) || (len(s)+3)/4 > len(ns.units) {
+		return ""
+	}
+
+	var b strings.Builder
+	for g := (len(s) - 1) / 4; g >= 0; g-- {
+		end := len(s) - g*4
+		start := max(end-4, 0)
+		group := s[start:end]
+
+		written := false
+		for i, c := range group {
+			d := int(c - '0')
+			if d == 0 {
+				continue
+			}
+			pos := len(group) - i - 1
+			if d != 1 || pos == 0 || !ns.omitOne {
+				b.WriteString(ns.digits[d])
+			}
+			b.WriteString(ns.subUnits[pos])
+			written = true
+		}
+		if written {
+			b.WriteString(ns.units[g])
+		}
+	}
+
+	return b.String()
+}
+
+// kanjiNumeral は整数の数字列を位取りした漢数字(例: 一万二千三百四十五)に変換する
+func kanjiNumeral(s string) string {
+	return kanjiStyle.format(s)
+}
+
+// replaceDigits は数字を1文字ずつdigitsの文字に置き換える
+func replaceDigits(s string, digits []string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			b.WriteString(digits[c-'0'])
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+var zenkakuDigits = []string{"０", "１", "２", "３", "４", "５", "６", "７", "８", "９"}
+
+// formatNumber は数字列を SKK の数値変換の型(#0〜#9)に従って整形する。
+// #4(数値の再変換)は辞書を引く必要があるため呼び出し側で処理する
+func formatNumber(num string, typ byte) (string, bool) {
+	switch typ {
+	case '0':
+		return num, true
+	case '1':
+		// 全角数字 (例: １２３)
+		return replaceDigits(num, zenkakuDigits), true
+	case '2':
+		// 位取りのない漢数字 (例: 一二三)
+		return replaceDigits(num, kanjiDigits), true
+	case '3':
+		// 位取りのある漢数字 (例: 百二十三)
+		s := kanjiNumeral(num)
+		return s, s != ""
+	case '5':
+		// 大字 (例: 百弐拾参)
+		s := daijiStyle.format(num)
+		return s, s != ""
+	case '8':
+		// 3桁ごとの区切り (例: 1,234)
+		return groupDigits(num), true
+	case '9':
+		// 将棋の棋譜 (例: ３四)
+		if len(num) != 2 {
+			return "", false
+		}
+		return zenkakuDigits[num[0]-'0'] + kanjiDigits[num[1]-'0'], true
+	}
+
+	return "", false
+}
+
+var reNumericType = regexp.MustCompile(`#[0-9]`)
+
+// expandNumeric は候補の中の #0〜#9 を先頭から順番に nums の数値で置き換える。
+// #4 は lookup で数値を再変換する
+func expandNumeric(cand string, nums []string, lookup func(string) string) (string, bool) {
+	i := 0
+	ok := true
+	s := reNumericType.ReplaceAllStringFunc(cand, func(m string) string {
+		if i >= len(nums) {
+			ok = false
+			return m
+		}
+		num := nums[i]
+		i++
+		if m[1] == '4' {
+			return lookup(num)
+		}
+		v, fok := formatNumber(num, m[1])
+		if !fok {
+			ok = false
+		}
+		return v
+	})
+
+	return s, ok
+}
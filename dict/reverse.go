@@ -0,0 +1,25 @@
+package dict
+
+import (
+	"sort"
+)
+
+// Reverse は候補 text を持つ見出し語を辞書順に返す。
+// 逆引きは管理画面などからたまに使うだけなので、索引をメモリに持たずに毎回全ての見出し語を走査する
+func (d *SkkDict) Reverse(text string) []string {
+	ls := []string{}
+	for _, s := range d.stores() {
+		s.Prefix("", func(label string, words []Word) bool {
+			for _, w := range words {
+				if w.Text == text {
+					// 同じ見出し語に同じ候補が複数ある場合は1つにする
+					ls = append(ls, label)
+					break
+				}
+			}
+			return true
+		})
+	}
+	sort.Strings(ls)
+	return ls
+}
@@ -2,7 +2,6 @@ package dict
 
 import (
 	"bufio"
-	"compress/gzip"
 	"fmt"
 	"io"
 	"log"
@@ -16,7 +15,6 @@ import (
 
 	"github.com/pkg/errors"
 	"golang.org/x/text/encoding"
-	"golang.org/x/text/encoding/japanese"
 	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
 )
@@ -25,7 +23,7 @@ var charCodePattern *regexp.Regexp
 var concatPattern *regexp.Regexp
 
 func init() {
-	charCodePattern = regexp.MustCompile(`\\(0\d+)`)
+	charCodePattern = regexp.MustCompile(`\\([0-7]{1,3}|.)`)
 	concatPattern = regexp.MustCompile(`\(concat\s+"((?:\\.|[^\\"])*)"\)`)
 }
 
@@ -45,6 +43,7 @@ type Entry struct {
 
 type Reader struct {
 	scanner *bufio.Scanner
+	line    int
 }
 
 type DicMap map[string][]Word
@@ -54,8 +53,13 @@ func (r *Reader) Read() (*Entry, error) {
 	if !ok {
 		return nil, io.EOF
 	}
+	r.line++
 
-	return parseLine(r.scanner.Text())
+	e, err := parseLine(r.scanner.Text())
+	if err != nil {
+		return nil, errors.Wrapf(err, "line %d", r.line)
+	}
+	return e, nil
 }
 
 func (r *Reader) ReadAll() ([]*Entry, error) {
@@ -97,13 +101,177 @@ func (r *Reader) ReadMap() (DicMap, error) {
 }
 
 type SkkDict struct {
-	dictMap map[string][]Word
+	dictMap Store
+	// abbrev モードの ASCII の見出し語
+	abbrevMap Store
+	// Lenient で読み込んだ場合の警告
+	warnings []Warning
+}
+
+// SkkDictOptions は SKK 辞書の読み込み方法の指定
+type SkkDictOptions struct {
+	// Compact は DicMap の代わりに Index で辞書を保持する
+	Compact bool
+	// Lenient は形式に誤りのある行を読み飛ばし、警告として記録する
+	Lenient bool
+	// Encoding は辞書の文字コード。空の場合は自動で判定する
+	Encoding string
+}
+
+func newSkkDict(m DicMap) *SkkDict {
+	dm, am := DicMap{}, DicMap{}
+	for label, ws := range m {
+		if IsAbbrev(label) {
+			am[label] = ws
+		} else {
+			dm[label] = ws
+		}
+	}
+	return &SkkDict{dictMap: dm, abbrevMap: am}
+}
+
+// readSkkDict は辞書を読み込む。Compact の場合は DicMap を経由せずに Index を作る
+func readSkkDict(r *Reader, opts *SkkDictOptions) (*SkkDict, error) {
+	if !opts.Compact && !opts.Lenient {
+		m, err := r.ReadMap()
+		return newSkkDict(m), err
+	}
+
+	dm, am := DicMap{}, DicMap{}
+	db, ab := &IndexBuilder{}, &IndexBuilder{}
+	add := func(e *Entry) {
+		switch {
+		case opts.Compact && IsAbbrev(e.Label):
+			ab.Add(e)
+		case opts.Compact:
+			db.Add(e)
+		case IsAbbrev(e.Label):
+			am[e.Label] = e.Words
+		default:
+			dm[e.Label] = e.Words
+		}
+	}
+
+	var warnings []Warning
+	if opts.Lenient {
+		ws, err := r.ReadLenient(add)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		warnings = ws[:min(len(ws), maxDictWarnings)]
+	} else {
+		for {
+			e, err := r.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, errors.WithStack(err)
+			}
+			if e != nil {
+				add(e)
+			}
+		}
+	}
+
+	if opts.Compact {
+		return &SkkDict{dictMap: db.Build(), abbrevMap: ab.Build(), warnings: warnings}, nil
+	}
+	return &SkkDict{dictMap: dm, abbrevMap: am, warnings: warnings}, nil
+}
+
+// Warnings は Lenient で読み込んだ場合の警告を返す
+func (d *SkkDict) Warnings() []Warning {
+	if d.warnings == nil {
+		return []Warning{}
+	}
+	return d.warnings
+}
+
+func (d *SkkDict) lookup(word string) ([]Word, bool, error) {
+	if IsAbbrev(word) {
+		return d.abbrevMap.Lookup(word)
+	}
+	return d.dictMap.Lookup(word)
+}
+
+// Prefix は prefix で始まる見出し語とその候補を辞書順に fn に渡す。
+// 空文字列や数字のように abbrev かどうかが prefix で決まらない場合は両方の見出し語を渡す
+func (d *SkkDict) Prefix(prefix string, fn func(label string, words []Word) bool) {
+	ss := d.stores()
+	switch {
+	case len(ss) == 1:
+		ss[0].Prefix(prefix, fn)
+	case IsAbbrev(prefix):
+		d.abbrevMap.Prefix(prefix, fn)
+	case strings.IndexFunc(prefix, func(r rune) bool { return r >= 0x80 }) >= 0:
+		// abbrev の見出し語は ASCII だけからなる
+		d.dictMap.Prefix(prefix, fn)
+	default:
+		mergePrefix(d.dictMap, d.abbrevMap, prefix, fn)
+	}
+}
+
+// mergePrefix は2つの Store の prefix で始まる見出し語を合わせて辞書順に fn に渡す。small は全て読み込むため小さい方を渡す
+func mergePrefix(large, small Store, prefix string, fn func(label string, words []Word) bool) {
+	es := []Entry{}
+	small.Prefix(prefix, func(label string, words []Word) bool {
+		es = append(es, Entry{Label: label, Words: words})
+		return true
+	})
+
+	stopped := false
+	large.Prefix(prefix, func(label string, words []Word) bool {
+		for len(es) > 0 && es[0].Label < label {
+			if !fn(es[0].Label, es[0].Words) {
+				stopped = true
+				return false
+			}
+			es = es[1:]
+		}
+		if !fn(label, words) {
+			stopped = true
+			return false
+		}
+		return true
+	})
+	if stopped {
+		return
+	}
+	for _, e := range es {
+		if !fn(e.Label, e.Words) {
+			return
+		}
+	}
+}
+
+// stores は見出し語を格納している Store を返す
+func (d *SkkDict) stores() []Store {
+	// CDB は通常の見出し語と abbrev の見出し語を同じ Store で持つ
+	if c, ok := d.dictMap.(*cdbStore); ok {
+		if a, ok := d.abbrevMap.(*cdbStore); ok && a == c {
+			return []Store{c}
+		}
+	}
+	return []Store{d.dictMap, d.abbrevMap}
+}
+
+// Len は見出し語の数を返す
+func (d *SkkDict) Len() int {
+	n := 0
+	for _, s := range d.stores() {
+		n += s.Len()
+	}
+	return n
 }
 
 func (d *SkkDict) Convert(word string) ([]string, error) {
-	ws, ok := d.dictMap[word]
+	ws, ok, err := d.lookup(word)
+	if err != nil {
+		return nil, errors.Wrap(err, word)
+	}
 	if !ok {
-		return []string{}, nil
+		return d.convertNumeric(word)
 	}
 
 	words := make([]string, len(ws))
@@ -114,31 +282,51 @@ func (d *SkkDict) Convert(word string) ([]string, error) {
 	return words, nil
 }
 
-func isURL(s string) bool {
-	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
-}
+// convertNumeric は読みの中の数字を # に置き換えて辞書を引き、候補の #0〜#9 を数値で置き換える
+func (d *SkkDict) convertNumeric(word string) ([]string, error) {
+	nums := reNumber.FindAllString(word, -1)
+	if len(nums) == 0 {
+		return []string{}, nil
+	}
+	label := reNumber.ReplaceAllString(word, "#")
+	ws, ok, err := d.lookup(label)
+	if err != nil {
+		return nil, errors.Wrap(err, label)
+	}
+	if !ok {
+		return []string{}, nil
+	}
 
-func isEUCJP(data []byte) bool {
-	for i := 0; i < len(data); i++ {
-		if data[i] >= 0x80 {
-			if data[i] >= 0xA1 && data[i] <= 0xFE {
-				if i+1 >= len(data) {
-					return false
-				}
-				if data[i+1] < 0xA1 || data[i+1] > 0xFE {
-					return false
-				}
-				i++
-			} else {
-				return false
-			}
+	lookup := func(num string) string {
+		if ws, ok, err := d.lookup(num); err == nil && ok && len(ws) > 0 {
+			return ws[0].Text
+		}
+		return num
+	}
+
+	words := []string{}
+	for _, w := range ws {
+		text, ok := expandNumeric(w.Text, nums, lookup)
+		if !ok {
+			continue
 		}
+		words = append(words, Word{Text: text, Desc: w.Desc}.String())
 	}
-	return true
+
+	return words, nil
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
 }
 
 func NewSkkDict(src, dir string, update bool) (*SkkDict, bool, error) {
-	var file *os.File
+	return NewSkkDictWithOptions(src, dir, update, &SkkDictOptions{})
+}
+
+func NewSkkDictWithOptions(src, dir string, update bool, opts *SkkDictOptions) (*SkkDict, bool, error) {
+	// zip や tar.gz などのアーカイブは「アーカイブ#メンバー名」の形式で中のファイルを指定する
+	src, member := splitArchiveMember(src)
 
 	if isURL(src) {
 		u, err := url.Parse(src)
@@ -149,8 +337,7 @@ func NewSkkDict(src, dir string, update bool) (*SkkDict, bool, error) {
 		fname := filepath.Base(u.Path)
 		fpath := filepath.Join(dir, fname)
 
-		file, err = os.Open(fpath)
-		if err == nil {
+		if _, err = os.Stat(fpath); err == nil {
 			// use cache file
 			log.Printf("use: %s\n", fpath)
 		} else if os.IsNotExist(err) {
@@ -164,98 +351,81 @@ func NewSkkDict(src, dir string, update bool) (*SkkDict, bool, error) {
 				return nil, false, fmt.Errorf("failed to download dictionary: %s", resp.Status)
 			}
 
-			file, err = os.Create(fpath)
+			f, err := os.Create(fpath)
 			if err != nil {
 				return nil, false, errors.WithStack(err)
 			}
-			defer file.Close()
+			defer f.Close()
 
-			if _, err := io.Copy(file, resp.Body); err != nil {
-				return nil, false, errors.WithStack(err)
-			}
-			if _, err := file.Seek(0, io.SeekStart); err != nil {
+			if _, err := io.Copy(f, resp.Body); err != nil {
 				return nil, false, errors.WithStack(err)
 			}
 		} else {
 			return nil, false, errors.WithStack(err)
 		}
+		src = fpath
 	} else {
-		var err error
-		file, err := os.Open(src)
-		if err != nil {
-			return nil, false, errors.WithStack(err)
-		}
-		defer file.Close()
 		update = false // ローカル辞書は更新しない
 	}
 
-	r, err := NewReader(file)
+	fpath, err := extractArchive(src, member, dir)
 	if err != nil {
 		return nil, update, errors.WithStack(err)
 	}
 
-	m, err := r.ReadMap()
-	sd := &SkkDict{dictMap: m}
-	return sd, update, err
-}
+	// コンパイル済み辞書と CDB は解析せずにそのまま開く
+	if sd, err := openBinaryDict(fpath); sd != nil || err != nil {
+		return sd, update, err
+	}
 
-func isGzip(r io.ReadSeeker) (bool, error) {
-	pos, err := r.Seek(0, io.SeekCurrent)
+	file, err := os.Open(fpath)
 	if err != nil {
-		return false, errors.WithStack(err)
+		return nil, update, errors.WithStack(err)
 	}
+	defer file.Close()
 
-	header := make([]byte, 2)
-	if _, err := r.Read(header); err != nil {
-		return false, errors.WithStack(err)
+	var enc encoding.Encoding
+	if opts.Encoding != "" {
+		var err error
+		enc, err = LookupEncoding(opts.Encoding)
+		if err != nil {
+			return nil, update, errors.WithStack(err)
+		}
 	}
 
-	if _, err := r.Seek(pos, io.SeekStart); err != nil {
-		return false, errors.WithStack(err)
+	r, err := NewReaderWithEncoding(file, enc)
+	if err != nil {
+		return nil, update, errors.WithStack(err)
 	}
 
-	return header[0] == 0x1f && header[1] == 0x8b, nil
+	sd, err := readSkkDict(r, opts)
+	return sd, update, err
 }
 
-func detectEncoding(r io.Reader) encoding.Encoding {
-	buf := make([]byte, 512)
-	n, err := r.Read(buf)
-	if err != nil && err != io.EOF {
-		log.Println("Error reading file:", err)
-		return unicode.UTF8
-	}
-	buf = buf[:n]
-
-	if isEUCJP(buf) {
-		return japanese.EUCJP
-	}
-
-	return unicode.UTF8
+func NewReader(r io.Reader) (*Reader, error) {
+	return NewReaderWithEncoding(r, nil)
 }
 
-func NewReader(file *os.File) (*Reader, error) {
-	gz, err := isGzip(file)
+// NewReaderWithEncoding は文字コードを指定して辞書を読み込む。enc が nil の場合は自動で判定する
+func NewReaderWithEncoding(r io.Reader, enc encoding.Encoding) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	// gzip, bzip2, xz で圧縮されている場合は展開する
+	br, err := decompress(br)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	var r io.Reader = file
-
-	if gz {
-		gr, err := gzip.NewReader(file)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		defer gr.Close()
-		r = gr
+	br = bufio.NewReaderSize(br, encodingSampleSize)
+	if enc == nil {
+		enc = detectEncoding(br)
 	}
-
-	enc := detectEncoding(r)
-	if enc == japanese.EUCJP {
-		r = transform.NewReader(r, enc.NewDecoder())
+	if enc == unicode.UTF8 {
+		// BOM を取り除く
+		enc = unicode.UTF8BOM
 	}
 
-	sc := bufio.NewScanner(r)
+	sc := bufio.NewScanner(transform.NewReader(br, enc.NewDecoder()))
 
 	return &Reader{scanner: sc}, nil
 }
@@ -268,7 +438,12 @@ func decode(str string) string {
 	str = charCodePattern.ReplaceAllStringFunc(matches[1], func(m string) string {
 		s := charCodePattern.FindStringSubmatch(m)
 		if len(s) > 1 {
-			code, err := strconv.Atoi(s[1])
+			if s[1][0] < '0' || s[1][0] > '7' {
+				// \" や \\ などのエスケープ
+				return s[1]
+			}
+			// \057 などの8進数の文字コード
+			code, err := strconv.ParseInt(s[1], 8, 32)
 			if err != nil {
 				log.Println(err)
 				return m
@@ -0,0 +1,108 @@
+package dict
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSkkDict = `;; okuri-nasi entries.
+あい /愛/藍/
+#ばん /#1番/
+1 /一/
+api /API;Application Programming Interface/
+zip /ZIP/
+かな /仮名/
+`
+
+func readTestSkkDict(t testing.TB, compact bool) *SkkDict {
+	t.Helper()
+	r, err := NewReader(strings.NewReader(testSkkDict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := readSkkDict(r, &SkkDictOptions{Compact: compact})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sd
+}
+
+func prefixLabels(sd *SkkDict, prefix string) []string {
+	labels := []string{}
+	sd.Prefix(prefix, func(label string, _ []Word) bool {
+		labels = append(labels, label)
+		return true
+	})
+	return labels
+}
+
+func TestSkkDictPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"#ばん", "1", "api", "zip", "あい", "かな"}},
+		{"a", []string{"api"}},
+		{"あ", []string{"あい"}},
+		{"1", []string{"1"}},
+	}
+
+	for _, compact := range []bool{false, true} {
+		sd := readTestSkkDict(t, compact)
+		for _, tt := range tests {
+			if got := prefixLabels(sd, tt.prefix); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compact=%v Prefix(%q) = %v, want %v", compact, tt.prefix, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestSkkDictPrefixStop(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		sd := readTestSkkDict(t, compact)
+		labels := []string{}
+		sd.Prefix("", func(label string, _ []Word) bool {
+			labels = append(labels, label)
+			return len(labels) < 3
+		})
+		if want := []string{"#ばん", "1", "api"}; !reflect.DeepEqual(labels, want) {
+			t.Errorf("compact=%v got %v, want %v", compact, labels, want)
+		}
+	}
+}
+
+func TestSkkDictReverse(t *testing.T) {
+	const dic = `;; okuri-nasi entries.
+あい /愛/藍/
+あいあい /愛;love/愛/
+ないと /夜/
+よる /夜/
+night /夜/
+`
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"夜", []string{"night", "ないと", "よる"}},
+		{"愛", []string{"あい", "あいあい"}},
+		{"藍", []string{"あい"}},
+		{"無", []string{}},
+	}
+
+	for _, compact := range []bool{false, true} {
+		r, err := NewReader(strings.NewReader(dic))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd, err := readSkkDict(r, &SkkDictOptions{Compact: compact})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			if got := sd.Reverse(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compact=%v Reverse(%q) = %v, want %v", compact, tt.text, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package dict
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/width"
+)
+
+//go:embed data/units.tsv
+var unitData string
+
+//go:embed data/unit_targets.tsv
+var unitTargetData string
+
+const (
+	// 変換結果の有効桁数。係数の誤差が候補に出ないように丸める
+	unitDigits = 10
+	// これより絶対値の小さい変換結果は候補にしない
+	unitMinValue = 1e-4
+)
+
+var reUnitQuantity = regexp.MustCompile(`^(-?[0-9]+(?:\.[0-9]+)?)\s*(\S+)$`)
+
+type unit struct {
+	Symbol string
+	Name   string
+	Kind   string
+	Factor float64
+	Offset float64
+}
+
+func (u *unit) toBase(v float64) float64 {
+	return v*u.Factor + u.Offset
+}
+
+func (u *unit) fromBase(v float64) float64 {
+	return (v - u.Offset) / u.Factor
+}
+
+type UnitDict struct {
+	units map[string][]*unit
+	// 種類ごとの変換先の単位。並べた順に候補にする
+	targets map[string][]*unit
+}
+
+// formatUnitValue は値を unitDigits 桁に丸め、指数表記を使わずに返す
+func formatUnitValue(v float64) string {
+	r, err := strconv.ParseFloat(strconv.FormatFloat(v, 'g', unitDigits, 64), 64)
+	if err != nil {
+		return formatDecimal(v, 0)
+	}
+	s := strconv.FormatFloat(r, 'f', -1, 64)
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+func (d *UnitDict) Convert(word string) ([]string, error) {
+	ms := reUnitQuantity.FindStringSubmatch(strings.ToLower(width.Narrow.String(word)))
+	if len(ms) < 3 {
+		return []string{}, nil
+	}
+	us, ok := d.units[ms[2]]
+	if !ok {
+		return []string{}, nil
+	}
+	v, err := strconv.ParseFloat(ms[1], 64)
+	if err != nil {
+		return []string{}, errors.WithStack(err)
+	}
+
+	words := []string{}
+	for _, u := range us {
+		src := formatUnitValue(v) + u.Symbol
+		words = append(words, Word{Text: src, Desc: u.Name}.String())
+
+		base := u.toBase(v)
+		for _, t := range d.targets[u.Kind] {
+			if t == u {
+				continue
+			}
+			cv := t.fromBase(base)
+			if math.IsInf(cv, 0) || math.IsNaN(cv) || (cv != 0 && math.Abs(cv) < unitMinValue) {
+				continue
+			}
+			words = append(words, Word{Text: formatUnitValue(cv) + t.Symbol, Desc: src}.String())
+		}
+	}
+
+	return words, nil
+}
+
+func parseUnitData(data, targets string) (*UnitDict, error) {
+	d := &UnitDict{units: map[string][]*unit{}, targets: map[string][]*unit{}}
+	symbols := map[string]*unit{}
+
+	sc := bufio.NewScanner(strings.NewReader(data))
+	n := 0
+	for sc.Scan() {
+		n++
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cols := strings.Split(line, "\t")
+		if len(cols) != 6 {
+			return nil, fmt.Errorf("invalid unit data at line %d: %s", n, line)
+		}
+		factor, err := strconv.ParseFloat(cols[3], 64)
+		if err != nil || factor == 0 {
+			return nil, fmt.Errorf("invalid factor at line %d: %s", n, cols[3])
+		}
+		offset, err := strconv.ParseFloat(cols[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset at line %d: %s", n, cols[4])
+		}
+
+		u := &unit{
+			Symbol: cols[0],
+			Name:   cols[1],
+			Kind:   cols[2],
+			Factor: factor,
+			Offset: offset,
+		}
+		symbols[u.Symbol] = u
+		for _, r := range strings.Fields(cols[5]) {
+			d.units[r] = append(d.units[r], u)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sc = bufio.NewScanner(strings.NewReader(targets))
+	n = 0
+	for sc.Scan() {
+		n++
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kind, syms, ok := strings.Cut(line, "\t")
+		if !ok {
+			return nil, fmt.Errorf("invalid unit target at line %d: %s", n, line)
+		}
+		for _, sym := range strings.Fields(syms) {
+			u, ok := symbols[sym]
+			if !ok || u.Kind != kind {
+				return nil, fmt.Errorf("unknown %s unit at line %d: %s", kind, n, sym)
+			}
+			d.targets[kind] = append(d.targets[kind], u)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return d, nil
+}
+
+func NewUnitDict() (*UnitDict, error) {
+	d, err := parseUnitData(unitData, unitTargetData)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,55 @@
+package dict
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnitDictConvert(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"1km", []string{"1km;キロメートル", "100000cm;1km", "1000m;1km", "39370.07874in;1km", "3280.839895ft;1km", "3300尺;1km"}},
+		// 係数の誤差を有効桁数で丸める
+		{"100しゃく", []string{"100尺;尺", "3030.30303cm;100尺", "30.3030303m;100尺", "0.0303030303km;100尺", "1193.032689in;100尺", "99.41939076ft;100尺"}},
+		{"1609344km", []string{"1609344km;キロメートル", "160934400000cm;1609344km", "1609344000m;1609344km", "63360000000in;1609344km", "5280000000ft;1609344km", "5310835200尺;1609344km"}},
+		// 変換先に書いた単位だけを候補にする
+		{"3つぼ", []string{"3坪;坪", "9.917355372㎡;3坪", "6.121824304畳;3坪", "0.0009917355372ha;3坪"}},
+		{"2.5l", []string{"2.5L;リットル", "2500mL;2.5L", "13.85880881合;2.5L", "0.6604301309gal;2.5L"}},
+		// オフセットのある単位
+		{"-40c", []string{"-40℃;摂氏", "-40℉;-40℃", "233.15K;-40℃"}},
+		{"100f", []string{"100℉;華氏", "37.77777778℃;100℉", "310.9277778K;100℉"}},
+		// 全角
+		{"１０ｋｍ", []string{"10km;キロメートル", "1000000cm;10km", "10000m;10km", "393700.7874in;10km", "32808.39895ft;10km", "33000尺;10km"}},
+		{"abc", []string{}},
+		{"10", []string{}},
+		{"1e3km", []string{}},
+	}
+
+	d, err := NewUnitDict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		got, err := d.Convert(tt.word)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Convert(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestParseUnitTargets(t *testing.T) {
+	data := "m\tメートル\tlength\t1\t0\tめーとる\ng\tグラム\tmass\t0.001\t0\tぐらむ\n"
+	if _, err := parseUnitData(data, "length\tm\n"); err != nil {
+		t.Fatal(err)
+	}
+	for _, targets := range []string{"length\tkm\n", "length\tg\n", "length m\n"} {
+		if _, err := parseUnitData(data, targets); err == nil {
+			t.Errorf("parseUnitData(%q) succeeded", targets)
+		}
+	}
+}
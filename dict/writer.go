@@ -0,0 +1,132 @@
+package dict
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+)
+
+// Writer は SKK-JISYO 形式で辞書を書き出す。Reader の対になるもの
+type Writer struct {
+	w   *bufio.Writer
+	enc encoding.Encoding
+	// StripDesc は注釈を取り除いて書き出す
+	StripDesc bool
+	// Skipped は文字コードで表せない文字を含むため書き出さなかったエントリ
+	Skipped []*Entry
+}
+
+// isOkuriAri は見出し語が送りありかどうかを返す(例: あるk)
+func isOkuriAri(label string) bool {
+	if len(label) < 2 || IsAbbrev(label) {
+		return false
+	}
+	c := label[len(label)-1]
+	return c >= 'a' && c <= 'z'
+}
+
+// encodeCandidate は候補や注釈の中の SKK 辞書で使えない文字を (concat "...") の形式でエスケープする
+func encodeCandidate(s string) string {
+	if !strings.ContainsAny(s, "/;") {
+		return s
+	}
+
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '/', ';', '"', '\\':
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return `(concat "` + b.String() + `")`
+}
+
+func (w *Writer) formatEntry(e *Entry) string {
+	var b strings.Builder
+	b.WriteString(e.Label)
+	b.WriteString(" /")
+	for _, wd := range e.Words {
+		b.WriteString(encodeCandidate(wd.Text))
+		if wd.Desc != "" && !w.StripDesc {
+			b.WriteString(";" + encodeCandidate(wd.Desc))
+		}
+		b.WriteString("/")
+	}
+	return b.String()
+}
+
+func (w *Writer) writeLine(line string) error {
+	s, err := w.enc.NewEncoder().String(line)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode: %s", line)
+	}
+	if _, err := w.w.WriteString(s + "\n"); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Write は1つのエントリを書き出す。文字コードで表せない文字を含む場合は書き出さずに Skipped に加える
+func (w *Writer) Write(e *Entry) error {
+	line := w.formatEntry(e)
+	if _, err := w.enc.NewEncoder().String(line); err != nil {
+		w.Skipped = append(w.Skipped, e)
+		return nil
+	}
+	return w.writeLine(line)
+}
+
+// WriteAll はエントリを送りありと送りなしに分け、それぞれの見出しを付けて SKK-JISYO の順番で書き出す。
+// 送りありは降順、送りなしは昇順に並べる
+func (w *Writer) WriteAll(es []*Entry) error {
+	ari, nasi := []*Entry{}, []*Entry{}
+	for _, e := range es {
+		if isOkuriAri(e.Label) {
+			ari = append(ari, e)
+		} else {
+			nasi = append(nasi, e)
+		}
+	}
+	sort.SliceStable(ari, func(i, j int) bool { return ari[i].Label > ari[j].Label })
+	sort.SliceStable(nasi, func(i, j int) bool { return nasi[i].Label < nasi[j].Label })
+
+	header := []string{
+		";; -*- mode: fundamental; coding: " + EncodingName(w.enc) + " -*-",
+		";; okuri-ari entries.",
+	}
+	for _, l := range header {
+		if err := w.writeLine(l); err != nil {
+			return err
+		}
+	}
+	for _, e := range ari {
+		if err := w.Write(e); err != nil {
+			return err
+		}
+	}
+	if err := w.writeLine(";; okuri-nasi entries."); err != nil {
+		return err
+	}
+	for _, e := range nasi {
+		if err := w.Write(e); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+func (w *Writer) Flush() error {
+	return errors.WithStack(w.w.Flush())
+}
+
+func NewWriter(w io.Writer, enc encoding.Encoding) *Writer {
+	return &Writer{w: bufio.NewWriter(w), enc: enc}
+}
@@ -0,0 +1,43 @@
+package dict
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestWriterSkipsUnencodable(t *testing.T) {
+	es := []*Entry{
+		{Label: "あい", Words: []Word{{Text: "愛"}}},
+		{Label: "えもじ", Words: []Word{{Text: "😀"}}},
+		{Label: "かな", Words: []Word{{Text: "仮名", Desc: "a/b"}}},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, japanese.EUCJP)
+	if err := w.WriteAll(es); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Skipped) != 1 || w.Skipped[0].Label != "えもじ" {
+		t.Errorf("Skipped = %v", w.Skipped)
+	}
+
+	out, err := japanese.EUCJP.NewDecoder().String(buf.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `;; -*- mode: fundamental; coding: euc-jp -*-
+;; okuri-ari entries.
+;; okuri-nasi entries.
+あい /愛/
+かな /仮名;(concat "a\057b")/
+`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+	if strings.Contains(out, "えもじ") {
+		t.Errorf("unencodable entry is written")
+	}
+}
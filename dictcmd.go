@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kan/bragi/dict"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/text/encoding"
+)
+
+// 辞書を書き出すコマンドで共通のフラグ
+var dictWriteFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage:   "出力ファイルパス (省略時は標準出力)",
+	},
+	&cli.StringFlag{
+		Name:    "encoding",
+		Aliases: []string{"e"},
+		Value:   "euc-jp",
+		Usage:   "出力する文字コード (euc-jp, utf-8)",
+	},
+	&cli.BoolFlag{
+		Name:  "strip-annotation",
+		Usage: "注釈を取り除く",
+	},
+}
+
+var dictCommand = &cli.Command{
+	Name:  "dict",
+	Usage: "辞書ファイルの操作",
+	Commands: []*cli.Command{
+		{
+			Name:      "compile",
+			Usage:     "SKK辞書をBragiのコンパイル済み辞書に変換",
+			ArgsUsage: "<file>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "出力ファイルパス (省略時は<file>.bragi)",
+				},
+			},
+			Action: compileDict,
+		},
+		{
+			Name:      "lint",
+			Usage:     "SKK辞書の形式の誤りを検査",
+			ArgsUsage: "<file>...",
+			Action:    lintDict,
+		},
+		{
+			Name:      "merge",
+			Usage:     "複数のSKK辞書を1つにまとめる",
+			ArgsUsage: "<file>...",
+			Flags:     dictWriteFlags,
+			Action:    mergeDict,
+		},
+		{
+			Name:      "subtract",
+			Usage:     "SKK辞書から他の辞書に含まれる候補を取り除く",
+			ArgsUsage: "<base> <file>...",
+			Flags:     dictWriteFlags,
+			Action:    subtractDict,
+		},
+		{
+			Name:      "sort",
+			Usage:     "SKK辞書を並べ替える",
+			ArgsUsage: "<file>",
+			Flags:     dictWriteFlags,
+			Action:    sortDict,
+		},
+		{
+			Name:      "bench",
+			Usage:     "辞書の格納方式ごとの読み込み時間とメモリ使用量の比較",
+			ArgsUsage: "<file>",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "lookups",
+					Value: 100000,
+					Usage: "検索時間の計測に使う検索回数",
+				},
+			},
+			Action: benchDict,
+		},
+	},
+}
+
+// readRSS は /proc/self/status から現在のプロセスの RSS を返す。取得できない環境では0を返す
+func readRSS() uint64 {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "VmRSS:"); ok {
+			kb, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "kB")), 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+func mib(n uint64) string {
+	return fmt.Sprintf("%.1fMiB", float64(n)/1024/1024)
+}
+
+func benchDict(ctx context.Context, cmd *cli.Command) error {
+	path := cmd.Args().First()
+	if path == "" {
+		return fmt.Errorf("dictionary file is required")
+	}
+
+	for _, compact := range []bool{false, true} {
+		name := "map"
+		if compact {
+			name = "compact"
+		}
+
+		var before, after runtime.MemStats
+		// 前の計測で使ったメモリを OS に返してから計測する
+		debug.FreeOSMemory()
+		runtime.ReadMemStats(&before)
+		rss := readRSS()
+
+		start := time.Now()
+		sd, _, err := dict.NewSkkDictWithOptions(path, os.TempDir(), false, &dict.SkkDictOptions{Compact: compact})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		load := time.Since(start)
+
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		// mmap した索引はヒープに含まれないため RSS も表示する
+		rssDiff := "-"
+		if now := readRSS(); rss > 0 {
+			rssDiff = mib(now - min(rss, now))
+		}
+
+		labels := []string{}
+		sd.Prefix("", func(label string, _ []dict.Word) bool {
+			labels = append(labels, label)
+			return true
+		})
+		lookup := time.Duration(0)
+		if n := int(cmd.Int("lookups")); n > 0 && len(labels) > 0 {
+			start = time.Now()
+			for i := 0; i < n; i++ {
+				if _, err := sd.Convert(labels[i%len(labels)]); err != nil {
+					return errors.WithStack(err)
+				}
+			}
+			lookup = time.Since(start) / time.Duration(n)
+		}
+
+		fmt.Printf("%-8s entries: %d, load: %v, heap: %s, rss: %s, lookup: %v/op\n",
+			name, sd.Len(), load, mib(after.HeapInuse-min(before.HeapInuse, after.HeapInuse)), rssDiff, lookup)
+
+		runtime.KeepAlive(sd)
+	}
+
+	return nil
+}
+
+func compileDict(ctx context.Context, cmd *cli.Command) error {
+	src := cmd.Args().First()
+	if src == "" {
+		return fmt.Errorf("dictionary file is required")
+	}
+	dst := cmd.String("output")
+	if dst == "" {
+		dst = strings.TrimSuffix(src, ".gz") + ".bragi"
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	r, err := dict.NewReader(f)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// 書き込み途中のファイルを読まれないように一時ファイルに書いてから置き換える
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := dict.CompileSkkDict(r, w); err != nil {
+		tmp.Close()
+		return errors.WithStack(err)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return errors.WithStack(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return errors.WithStack(err)
+	}
+
+	fmt.Printf("Compiled dictionary: %s\n", dst)
+	return nil
+}
+
+func readEntries(path string) ([]*dict.Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	r, err := dict.NewReader(f)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	es, err := r.ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	return es, nil
+}
+
+func readEntriesList(paths []string) ([][]*dict.Entry, error) {
+	lists := [][]*dict.Entry{}
+	for _, path := range paths {
+		es, err := readEntries(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		lists = append(lists, es)
+	}
+	return lists, nil
+}
+
+func writeEntries(cmd *cli.Command, es []*dict.Entry) error {
+	enc, err := dict.LookupEncoding(cmd.String("encoding"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	path := cmd.String("output")
+	if path == "" {
+		return writeDict(os.Stdout, cmd, enc, es)
+	}
+
+	// 書き込みに失敗した場合に元のファイルを壊さないように一時ファイルに書いてから置き換える
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeDict(tmp, cmd, enc, es); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// writeDict は辞書を書き出す。文字コードで表せないため書き出さなかったエントリは標準エラー出力に表示する
+func writeDict(out io.Writer, cmd *cli.Command, enc encoding.Encoding, es []*dict.Entry) error {
+	w := dict.NewWriter(out, enc)
+	w.StripDesc = cmd.Bool("strip-annotation")
+	if err := w.WriteAll(es); err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, e := range w.Skipped {
+		fmt.Fprintf(os.Stderr, "skipped: %s: cannot be encoded in %s\n", e.Label, dict.EncodingName(enc))
+	}
+	return nil
+}
+
+func mergeDict(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() == 0 {
+		return fmt.Errorf("dictionary files are required")
+	}
+	lists, err := readEntriesList(cmd.Args().Slice())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return writeEntries(cmd, dict.MergeEntries(lists...))
+}
+
+func subtractDict(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() < 2 {
+		return fmt.Errorf("base dictionary and dictionaries to subtract are required")
+	}
+	lists, err := readEntriesList(cmd.Args().Slice())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return writeEntries(cmd, dict.SubtractEntries(lists[0], lists[1:]...))
+}
+
+func sortDict(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() != 1 {
+		return fmt.Errorf("a dictionary file is required")
+	}
+	es, err := readEntries(cmd.Args().First())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return writeEntries(cmd, dict.MergeEntries(es))
+}
+
+func lintDict(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() == 0 {
+		return fmt.Errorf("dictionary files are required")
+	}
+
+	total := 0
+	for _, path := range cmd.Args().Slice() {
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		r, err := dict.NewReader(f)
+		if err != nil {
+			f.Close()
+			return errors.WithStack(err)
+		}
+		n := 0
+		ws, err := r.ReadLenient(func(e *dict.Entry) { n++ })
+		f.Close()
+		if err != nil {
+			return errors.Wrap(err, path)
+		}
+
+		for _, w := range ws {
+			fmt.Printf("%s:%s\n", path, w)
+		}
+		fmt.Printf("%s: %d entries, %d problems\n", path, n, len(ws))
+		total += len(ws)
+	}
+
+	if total > 0 {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
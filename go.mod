@@ -5,9 +5,11 @@ go 1.22
 toolchain go1.23
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/knadh/koanf v1.5.0
 	github.com/pkg/errors v0.9.1
 	github.com/sashabaranov/go-openai v1.24.1
+	github.com/ulikunitz/xz v0.5.12
 )
 
 require (
@@ -17,7 +19,6 @@ require (
 )
 
 require (
-	github.com/fsnotify/fsnotify v1.4.9 // indirect
 	github.com/mitchellh/copystructure v1.2.0 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/mitchellh/reflectwalk v1.0.2 // indirect
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/kan/bragi/server"
+	"github.com/pkg/errors"
+)
+
+// Unix ドメインソケットを指定する待ち受けアドレスの接頭辞
+const unixAddrPrefix = "unix:"
+
+// listen は addr で待ち受ける。「unix:パス」の場合は Unix ドメインソケットを mode のパーミッションで作る
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+	if !ok {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup TCP server on %s: %+v", addr, err)
+		}
+		return l, nil
+	}
+
+	// 前回異常終了したときに残ったソケットファイルを消す
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("unix socket is already in use: %s", path)
+		}
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup unix socket server on %s: %+v", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, errors.WithStack(err)
+	}
+	return l, nil
+}
+
+// listenerAddr は待ち受けのアドレスを設定と同じ形式で返す
+func listenerAddr(l net.Listener) string {
+	if l.Addr().Network() == "unix" {
+		return unixAddrPrefix + l.Addr().String()
+	}
+	return l.Addr().String()
+}
+
+// skkListener は1つのアドレスでの待ち受け
+type skkListener struct {
+	addr string
+	mu   sync.Mutex
+	// 異常終了した場合は nil にして、再実行するときに待ち受け直す
+	l      net.Listener
+	mode   os.FileMode
+	cancel context.CancelFunc
+	done   <-chan struct{}
+}
+
+func (sl *skkListener) stop() {
+	sl.cancel()
+	<-sl.done
+}
+
+func (sl *skkListener) listener() net.Listener {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.l
+}
+
+// run は待ち受けて接続を処理する。待ち受けが異常終了した場合はエラーを返し、Supervisor から再実行される
+func (sl *skkListener) run(ctx context.Context, s *server.Server, tc *tls.Config) error {
+	sl.mu.Lock()
+	if sl.l == nil {
+		l, err := listen(sl.addr, sl.mode)
+		if err != nil {
+			sl.mu.Unlock()
+			return err
+		}
+		slog.Info("Bragi server is running", "addr", sl.addr)
+		sl.l = l
+	}
+	l := sl.l
+	sl.mu.Unlock()
+
+	// 引き継ぎのために sl.l には TLS で包む前の待ち受けを残す
+	if tc != nil {
+		l = tls.NewListener(l, tc)
+	}
+	err := serveSKK(ctx, l, s)
+	if errors.Is(err, context.Canceled) {
+		slog.Info("skk server stopped gracefully", "addr", sl.addr)
+		return nil
+	}
+
+	sl.mu.Lock()
+	sl.l = nil
+	sl.mu.Unlock()
+	return err
+}
+
+// skkListeners は SKK サーバーの待ち受けをアドレスごとに管理する。全ての待ち受けで同じ server.Server を使う
+type skkListeners struct {
+	s       *server.Server
+	sv      *server.Supervisor
+	running map[string]*skkListener
+	// systemd や以前のプロセスから引き継いだ待ち受け。同じアドレスで待ち受ける場合に使う
+	inherited map[string]net.Listener
+	// systemd から待ち受けを渡された場合は設定の待ち受けアドレスを使わない
+	fixed bool
+	// nil でない場合は TLS で待ち受ける
+	tls *tls.Config
+}
+
+func newSKKListeners(s *server.Server, sv *server.Supervisor, tc *tls.Config) *skkListeners {
+	return &skkListeners{s: s, sv: sv, running: map[string]*skkListener{}, inherited: map[string]net.Listener{}, tls: tc}
+}
+
+// inherit は引き継いだ待ち受けを登録する。fixed の場合は設定によらず引き継いだ待ち受けだけを使う
+func (ls *skkListeners) inherit(inherited map[string]net.Listener, fixed bool) {
+	for addr, l := range inherited {
+		ls.inherited[addr] = l
+	}
+	ls.fixed = fixed
+}
+
+// update は addrs で待ち受けるようにする。変更のないアドレスはそのまま待ち受けを続ける。
+// 新しいアドレスで待ち受けられない場合は元の待ち受けを変更しない
+func (ls *skkListeners) update(addrs []string, mode os.FileMode) error {
+	if ls.fixed {
+		addrs = []string{}
+		for addr := range ls.running {
+			addrs = append(addrs, addr)
+		}
+		for addr := range ls.inherited {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	started := map[string]net.Listener{}
+	for _, addr := range addrs {
+		if sl, ok := ls.running[addr]; ok {
+			sl.mu.Lock()
+			sl.mode = mode
+			sl.mu.Unlock()
+			if path, ok := strings.CutPrefix(addr, unixAddrPrefix); ok {
+				if err := os.Chmod(path, mode); err != nil {
+					slog.Warn("failed to change socket mode", "addr", addr, "err", err)
+				}
+			}
+			continue
+		}
+		if _, ok := started[addr]; ok {
+			continue
+		}
+		if l, ok := ls.inherited[addr]; ok {
+			slog.Info("Use inherited listener", "addr", addr)
+			started[addr] = l
+			continue
+		}
+		l, err := listen(addr, mode)
+		if err != nil {
+			for addr, l := range started {
+				if _, ok := ls.inherited[addr]; !ok {
+					l.Close()
+				}
+			}
+			return err
+		}
+		started[addr] = l
+	}
+
+	for addr, l := range started {
+		ctx, cancel := context.WithCancel(context.Background())
+		sl := &skkListener{addr: addr, l: l, mode: mode, cancel: cancel}
+		sl.done = ls.sv.Go(ctx, "skk "+addr, func(ctx context.Context) error {
+			return sl.run(ctx, ls.s, ls.tls)
+		})
+		ls.running[addr] = sl
+		slog.Info("Bragi server is running", "addr", addr, "tls", ls.tls != nil)
+	}
+
+	keep := map[string]bool{}
+	for _, addr := range addrs {
+		keep[addr] = true
+	}
+	for addr, sl := range ls.running {
+		if !keep[addr] {
+			sl.stop()
+			delete(ls.running, addr)
+		}
+	}
+	// 設定で使わなかった引き継いだ待ち受けは閉じる
+	for addr, l := range ls.inherited {
+		if _, ok := ls.running[addr]; !ok {
+			slog.Info("Close unused inherited listener", "addr", addr)
+			l.Close()
+		}
+		delete(ls.inherited, addr)
+	}
+
+	return nil
+}
+
+// listenerFiles は新しいプロセスに引き継ぐための待ち受けのファイルを複製して返す
+func listenerFiles(ls map[string]net.Listener) ([]string, []*os.File, error) {
+	addrs := []string{}
+	files := []*os.File{}
+	for addr, l := range ls {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		f, err := fl.File()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, errors.WithStack(err)
+		}
+		addrs = append(addrs, addr)
+		files = append(files, f)
+	}
+	return addrs, files, nil
+}
+
+// listeners は待ち受けをアドレスごとに返す
+func (ls *skkListeners) listeners() map[string]net.Listener {
+	m := map[string]net.Listener{}
+	for addr, sl := range ls.running {
+		if l := sl.listener(); l != nil {
+			m[addr] = l
+		}
+	}
+	return m
+}
+
+// handOver は新しいプロセスに引き継いだ待ち受けを止める。Unix ドメインソケットのファイルは消さない
+func (ls *skkListeners) handOver() {
+	for _, sl := range ls.running {
+		if ul, ok := sl.listener().(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	ls.close()
+}
+
+// close は全ての待ち受けを止める
+func (ls *skkListeners) close() {
+	for addr, sl := range ls.running {
+		sl.stop()
+		delete(ls.running, addr)
+	}
+}
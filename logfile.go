@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/kan/bragi/config"
+	"github.com/pkg/errors"
+)
+
+// logLevel はログの出力レベル。設定の再読み込みで変更する
+var logLevel = new(slog.LevelVar)
+
+// setupLogger は設定に従ってログの出力先と形式を設定する。log パッケージの出力も同じ出力先に出力する。
+// 出力先がファイルの場合は返した io.Closer で閉じる。出力先と形式の変更は再起動後に反映する
+func setupLogger(conf *config.Config) (io.Closer, error) {
+	level, err := conf.GetLogLevel()
+	if err != nil {
+		return nil, err
+	}
+	logLevel.Set(level)
+
+	var w io.Writer = os.Stderr
+	var closer io.Closer
+	if conf.LogFile != "" {
+		rw, err := newRotateWriter(conf.LogFile, int64(conf.LogMaxSize)*1024*1024, conf.LogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		w, closer = rw, rw
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler
+	switch conf.LogFormat {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text", "":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log_format: %s", conf.LogFormat)
+	}
+	slog.SetDefault(slog.New(h))
+
+	return closer, nil
+}
+
+// rotateWriter はファイルが maxSize を超えたら「ファイル名.1」に移して新しいファイルに書き込む。
+// 古いファイルは「ファイル名.maxBackups」まで残す
+type rotateWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func newRotateWriter(path string, maxSize int64, maxBackups int) (*rotateWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	w := &rotateWriter{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotateWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+	w.f, w.size = f, fi.Size()
+	return nil
+}
+
+func (w *rotateWriter) rotate() error {
+	w.f.Close()
+
+	var err error
+	backup := func(i int) string { return fmt.Sprintf("%s.%d", w.path, i) }
+	if w.maxBackups <= 0 {
+		err = os.Remove(w.path)
+	} else {
+		os.Remove(backup(w.maxBackups))
+		for i := w.maxBackups - 1; i >= 1; i-- {
+			os.Rename(backup(i), backup(i+1))
+		}
+		err = os.Rename(w.path, backup(1))
+	}
+
+	// 移せなかった場合も元のファイルを開き直して書き込みを続ける
+	if oerr := w.open(); oerr != nil {
+		return oerr
+	}
+	return errors.WithStack(err)
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rotate log file: %v\n", err)
+		}
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
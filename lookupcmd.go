@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kan/bragi/config"
+	"github.com/kan/bragi/dict"
+	"github.com/kan/bragi/server"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+)
+
+var lookupCommand = &cli.Command{
+	Name:      "lookup",
+	Usage:     "SKK辞書ごとに変換候補を検索",
+	ArgsUsage: "<word>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "reverse",
+			Aliases: []string{"r"},
+			Usage:   "変換候補から見出し語を逆引きする",
+		},
+		&cli.StringSliceFlag{
+			Name:    "dict",
+			Aliases: []string{"d"},
+			Usage:   "検索する辞書 (省略時は設定ファイルの辞書)",
+		},
+	},
+	Action: lookup,
+}
+
+func lookup(ctx context.Context, cmd *cli.Command) error {
+	word := cmd.Args().First()
+	if word == "" {
+		return fmt.Errorf("word is required")
+	}
+
+	conf, err := config.LoadConfig(cmd.String("config"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dir, err := conf.GetCacheDir()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	dics := cmd.StringSlice("dict")
+	if len(dics) == 0 {
+		dics = conf.Dictionary
+	}
+
+	for _, dic := range dics {
+		sd, _, err := dict.NewSkkDictWithOptions(dic, dir, false, server.SkkDictOptions(conf, dic))
+		if err != nil {
+			fmt.Printf("%s: error: %v\n", dic, err)
+			continue
+		}
+
+		var rs []string
+		if cmd.Bool("reverse") {
+			rs = sd.Reverse(word)
+		} else {
+			rs, err = sd.Convert(word)
+		}
+		sd.Close()
+		if err != nil {
+			fmt.Printf("%s: error: %v\n", dic, err)
+			continue
+		}
+		for i, r := range rs {
+			// 注釈のない候補は末尾の ; を取り除く
+			rs[i] = strings.TrimSuffix(r, ";")
+		}
+		if len(rs) > 0 {
+			fmt.Printf("%s: %s\n", dic, strings.Join(rs, " "))
+		}
+	}
+
+	return nil
+}
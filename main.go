@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/kan/bragi/admin"
@@ -83,7 +88,7 @@ func main() {
 	}
 	cmd := &cli.Command{
 		Name:    "bragi",
-		Version: "0.0.1",
+		Version: server.Version(),
 		Usage:   "tiny skk server",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -171,25 +176,37 @@ func main() {
 			},
 			{
 				Name:  "status",
-				Usage: "サービス登録したSKKサーバーの状態確認",
+				Usage: "SKKサーバーの状態確認",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "cert",
+						Usage: "管理画面に提示するクライアント証明書のファイル",
+					},
+					&cli.StringFlag{
+						Name:  "key",
+						Usage: "クライアント証明書の秘密鍵のファイル",
+					},
+				},
 				Action: func(ctx context.Context, c *cli.Command) error {
 					s, err := loadService(c.String("config"))
 					if err != nil {
 						return errors.WithStack(err)
 					}
 					sts, err := s.Status()
-					if err != nil {
-						return errors.WithStack(err)
-					}
-					switch sts {
-					case service.StatusRunning:
+					switch {
+					case errors.Is(err, service.ErrNotInstalled):
+						fmt.Println("Service not installed.")
+					case err != nil:
+						fmt.Printf("Service unknown: %v\n", err)
+					case sts == service.StatusRunning:
 						fmt.Println("Service running.")
-					case service.StatusStopped:
+					case sts == service.StatusStopped:
 						fmt.Println("Service stopped.")
 					default:
 						fmt.Println("Service unknown.")
 					}
-					return nil
+					// サービス登録せずに実行している場合も処理の状態を表示する
+					return printComponentStatus(ctx, c.String("config"), c.String("cert"), c.String("key"))
 				},
 			},
 			{
@@ -204,6 +221,9 @@ func main() {
 				Usage:  "リモート辞書の更新",
 				Action: update,
 			},
+			dictCommand,
+			lookupCommand,
+			reloadCommand,
 		},
 		Action: func(ctx context.Context, c *cli.Command) error {
 			// デフォルトコマンド
@@ -211,91 +231,205 @@ func main() {
 		},
 	}
 
-	if err := cmd.Run(context.Background(), os.Args); err != nil {
+	// 割り込みと終了のシグナルを受け取ったら処理中のリクエストを待ってから終了する
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cmd.Run(ctx, os.Args); err != nil {
 		log.Fatalf("%+v", err)
 	}
 }
 
+// アップグレード時に管理画面の待ち受けを引き継ぐための名前
+const adminListenerName = "admin"
+
 func serve(ctx context.Context, cpath string) error {
+	// 辞書の読み込み中に受け取ったシグナルでプロセスが終了しないように最初に登録する
+	upgradeChan := upgradeSignal()
+	reloadChan := reloadSignal()
+
 	conf, err := config.LoadConfig(cpath)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	log.Printf("%+v", conf)
+	closer, err := setupLogger(conf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	// 秘密の値は Config.LogValue で隠す
+	slog.Info("Load config", "path", cpath, "config", conf)
 
 	restartChan := make(chan struct{}, 1)
 
-	var skkCtx context.Context
-	var cancelSKK context.CancelFunc
+	s, err := server.LoadServer(conf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-	runSKK := func(conf *config.Config) {
-		skkCtx, cancelSKK = context.WithCancel(context.Background())
-		go func() {
-			if err := serveSKK(skkCtx, conf); err != nil {
-				if errors.Is(err, context.Canceled) {
-					log.Println("skk server stopped gracefully")
-				} else {
-					log.Fatalf("skk server failed: %v", err)
-				}
+	// SKK サーバーの待ち受けや管理画面は異常終了しても Supervisor が再実行する
+	sv := server.NewSupervisor()
+	svCtx, stopComponents := context.WithCancel(context.Background())
+	defer stopComponents()
+	sv.Go(svCtx, "watch", s.Watch)
+
+	mode, err := conf.GetSocketMode()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	skkTLS, adminTLS, err := newTLSConfig(conf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	listeners := newSKKListeners(s, sv, skkTLS)
+
+	inherited, fixed, err := inheritedListeners()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// 管理画面の待ち受けはアップグレード前のプロセスからのみ引き継ぐ
+	adminL, ok := inherited[adminListenerName]
+	delete(inherited, adminListenerName)
+	if fixed {
+		slog.Info("Use listeners passed by systemd", "count", len(inherited))
+	}
+	listeners.inherit(inherited, fixed)
+
+	// updateSKK は設定を読み込み直して辞書を入れ替える。待ち受けアドレスが変わった場合のみ待ち受け直す。
+	// 設定の検査、辞書の読み込み、待ち受け直しが全て成功してから入れ替え、失敗した場合は元の設定と辞書を使い続ける
+	updateSKK := func() {
+		err := func() error {
+			cf, err := config.LoadConfig(cpath)
+			if err != nil {
+				return err
+			}
+			mode, err := cf.GetSocketMode()
+			if err != nil {
+				return err
+			}
+			level, err := cf.GetLogLevel()
+			if err != nil {
+				return err
+			}
+			pending, err := s.Prepare(cf)
+			if err != nil {
+				return err
+			}
+			if err := listeners.update(cf.GetListenAddrs(), mode); err != nil {
+				pending.Discard()
+				return err
 			}
+			pending.Commit()
+			logLevel.Set(level)
+			return nil
 		}()
+		s.RecordConfigReload(cpath, err)
+		if err != nil {
+			slog.Error("failed to reload config, keep the previous config", "path", cpath, "err", err)
+			return
+		}
+		slog.Info("Reload config", "path", cpath)
 	}
 
-	restartSKK := func() {
-		if cancelSKK != nil {
-			cancelSKK()
-		}
-		time.Sleep(100 * time.Millisecond)
-		cf, err := config.LoadConfig(cpath)
+	if err := listeners.update(conf.GetListenAddrs(), mode); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !ok {
+		adminL, err = listen(":"+conf.AdminPort, 0)
 		if err != nil {
-			log.Fatal("load config error", err)
+			listeners.close()
+			return errors.WithStack(err)
+		}
+	}
+	web := &webServer{l: adminL, tls: adminTLS, path: cpath, c: restartChan, s: s, sv: sv}
+	sv.Go(svCtx, "admin", web.run)
+
+	// shutdown は管理画面と SKK サーバーの処理中のリクエストが終わるのを待ってから全ての処理を止める
+	shutdown := func() {
+		stopComponents()
+		ctx, cancel := context.WithTimeout(context.Background(), s.Config().GetShutdownTimeout())
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			slog.Warn("closed remaining connections", "err", err)
 		}
-		runSKK(cf)
+		sv.Wait()
 	}
 
-	runSKK(conf)
+	notifyReady()
 
-	go func() {
-		if err := serveWeb(conf, cpath, restartChan); err != nil {
-			log.Fatalf("web server failed: %v", err)
+	if path, err := conf.GetPidFile(); err != nil {
+		slog.Warn("failed to get pid file", "err", err)
+	} else {
+		pf := newPidFile(path)
+		defer pf.release()
+		// アップグレード後の新しいプロセスは前のプロセスが終わるまでロックを待つ
+		go func() {
+			if locked, _ := isLocked(path); locked {
+				slog.Info("Waiting for the pid file to be released", "path", path)
+			}
+			if err := pf.acquire(); err != nil {
+				slog.Warn("failed to write pid file", "path", path, "err", err)
+			}
+		}()
+	}
+
+	// upgradeSKK は新しいプロセスに待ち受けを引き継ぐ。引き継ぎに成功した場合は true を返す
+	upgradeSKK := func() bool {
+		ls := listeners.listeners()
+		if l := web.listener(); l != nil {
+			ls[adminListenerName] = l
 		}
-	}()
+		addrs, files, err := listenerFiles(ls)
+		if err != nil {
+			slog.Error("failed to upgrade", "err", err)
+			return false
+		}
+		if err := upgrade(addrs, files); err != nil {
+			slog.Error("failed to upgrade", "err", err)
+			return false
+		}
+		listeners.handOver()
+		return true
+	}
 
-	go func() {
-		for {
-			select {
-			case <-restartChan:
-				log.Println("Restarting SKK server due to config change...")
-				restartSKK()
-			case <-ctx.Done():
-				return
+	for {
+		select {
+		case <-restartChan:
+			slog.Info("Reloading dictionaries due to config change...")
+			updateSKK()
+		case <-reloadChan:
+			slog.Info("Reloading config due to SIGHUP...")
+			updateSKK()
+		case <-upgradeChan:
+			slog.Info("Upgrading to a new process...")
+			if upgradeSKK() {
+				slog.Info("Handed over listeners to the new process, shutting down...")
+				shutdown()
+				return nil
 			}
+		case <-ctx.Done():
+			slog.Info("Received interrupt signal, shutting down...")
+			listeners.close()
+			shutdown()
+			return nil
 		}
-	}()
-
-	<-ctx.Done()
-	log.Println("Received interrupt signal, shutting down...")
-
-	if cancelSKK != nil {
-		cancelSKK()
 	}
-
-	return nil
 }
 
-func serveSKK(ctx context.Context, conf *config.Config) error {
-	l, err := net.Listen("tcp", ":"+conf.Port)
-	if err != nil {
-		return fmt.Errorf("failed to setup TCP server on port %s: %+v", conf.Port, err)
-	}
-	defer l.Close()
+// 接続を受け付けられなかった場合に受け付け直すまでの待ち時間
+const (
+	acceptMinDelay = 5 * time.Millisecond
+	acceptMaxDelay = time.Second
+)
 
-	s, err := server.LoadServer(conf)
-	if err != nil {
-		return errors.WithStack(err)
-	}
+func serveSKK(ctx context.Context, l net.Listener, s *server.Server) error {
+	defer l.Close()
 
 	stopChan := make(chan struct{})
+	var delay time.Duration
 
 	go func() {
 		<-ctx.Done()
@@ -310,24 +444,96 @@ func serveSKK(ctx context.Context, conf *config.Config) error {
 			case <-stopChan:
 				return context.Canceled
 			default:
-				log.Println("Failed to accpet connection:", err)
-				continue
 			}
+			// 待ち受けが閉じられた場合や失敗が続く場合は Supervisor に待ち受け直してもらう
+			if errors.Is(err, net.ErrClosed) || delay >= acceptMaxDelay {
+				return errors.WithStack(err)
+			}
+			// ファイルディスクリプタが足りない場合などは少し待ってから受け付け直す
+			slog.Warn("Failed to accept connection", "err", err)
+			delay = min(max(delay*2, acceptMinDelay), acceptMaxDelay)
+			time.Sleep(delay)
+			continue
 		}
+		delay = 0
 
-		log.Println("accept connection")
-		go s.Serve(conn)
+		release, err := s.Admit(conn)
+		if err != nil {
+			slog.Warn("Reject connection", "remote", conn.RemoteAddr(), "err", err)
+			conn.Close()
+			continue
+		}
+
+		slog.Debug("accept connection", "remote", conn.RemoteAddr())
+		go func() {
+			defer release()
+			s.Serve(conn)
+		}()
 	}
 }
 
-func serveWeb(conf *config.Config, path string, c chan struct{}) error {
-	s := admin.LoadServer(conf, path, c)
+// webServer は管理画面の待ち受けと設定
+type webServer struct {
+	mu sync.Mutex
+	// 異常終了した場合は nil にして、再実行するときに待ち受け直す
+	l    net.Listener
+	tls  *tls.Config
+	path string
+	c    chan struct{}
+	s    *server.Server
+	sv   *server.Supervisor
+}
+
+func (ws *webServer) listener() net.Listener {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.l
+}
 
-	if err := s.Serve(); err != nil {
-		errors.WithStack(err)
+// run は管理画面を起動する。ctx が終了した場合は処理中のリクエストが終わるのを待ってから止める
+func (ws *webServer) run(ctx context.Context) error {
+	// 再起動した場合も再読み込み後の設定を使う
+	conf := ws.s.Config()
+	ws.mu.Lock()
+	if ws.l == nil {
+		l, err := listen(":"+conf.AdminPort, 0)
+		if err != nil {
+			ws.mu.Unlock()
+			return err
+		}
+		ws.l = l
 	}
+	l := ws.l
+	ws.mu.Unlock()
 
-	return nil
+	if ws.tls != nil {
+		l = tls.NewListener(l, ws.tls)
+	}
+	a := admin.LoadServer(conf, ws.path, ws.c, func() *server.Server { return ws.s })
+	a.Listener = l
+	a.Status = ws.sv.Status
+
+	errc := make(chan error, 1)
+	go func() { errc <- a.Serve() }()
+
+	select {
+	case err := <-errc:
+		ws.mu.Lock()
+		ws.l = nil
+		ws.mu.Unlock()
+		if err == nil {
+			err = fmt.Errorf("web server stopped unexpectedly")
+		}
+		return err
+	case <-ctx.Done():
+		sctx, cancel := context.WithTimeout(context.Background(), ws.s.Config().GetShutdownTimeout())
+		defer cancel()
+		if err := a.Shutdown(sctx); err != nil {
+			slog.Warn("failed to shutdown web server", "err", err)
+		}
+		<-errc
+		return nil
+	}
 }
 
 func update(ctx context.Context, cmd *cli.Command) error {
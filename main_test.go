@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// テストから bragi のプロセスを起動するときはテストのバイナリを bragi として実行する
+const envTestMain = "BRAGI_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(envTestMain) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// writeTestConfig は小さな SKK 辞書だけを使う設定ファイルを作り、そのパスを返す。extra は設定の末尾に追加する
+func writeTestConfig(t *testing.T, extra string) string {
+	t.Helper()
+	dir := t.TempDir()
+	dic := filepath.Join(dir, "test.dic")
+	if err := os.WriteFile(dic, []byte(";; okuri-nasi entries.\nてすと /試験/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := fmt.Sprintf(`admin_port = "0"
+use_ai = false
+use_lisp = false
+use_emoji = false
+use_calc = false
+use_unit = false
+use_number = false
+use_abbrev = false
+dictionary = [%q]
+dict_path = %q
+pid_file = %q
+log_level = "debug"
+%s`, dic, dir, filepath.Join(dir, "bragi.pid"), extra)
+	cpath := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(cpath, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return cpath
+}
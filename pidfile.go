@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// pidFile は実行中のプロセス ID を書き込むファイル。実行中はファイルをロックし、ロックの有無で実行中かどうかを判断できるようにする
+type pidFile struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	// 終了処理を始めた後にロックが取れた場合は書き込まない
+	released bool
+}
+
+func newPidFile(path string) *pidFile {
+	return &pidFile{path: path}
+}
+
+// acquire はファイルをロックしてプロセス ID を書き込む。
+// アップグレード前のプロセスなど他のプロセスがロックしている場合は解放されるまで待つ
+func (p *pidFile) acquire() error {
+	if err := os.MkdirAll(filepath.Dir(p.path), os.ModePerm); err != nil {
+		return errors.WithStack(err)
+	}
+
+	for {
+		f, err := os.OpenFile(p.path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return err
+		}
+		// ロックを待っている間に前のプロセスがファイルを消した場合は作り直す
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return errors.WithStack(err)
+		}
+		if pi, err := os.Stat(p.path); err != nil || !os.SameFile(fi, pi) {
+			f.Close()
+			continue
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.released {
+			f.Close()
+			return nil
+		}
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return errors.WithStack(err)
+		}
+		if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+			f.Close()
+			return errors.WithStack(err)
+		}
+		p.f = f
+		return nil
+	}
+}
+
+// release はファイルを消してロックを解放する。アップグレード後の新しいプロセスが書き込んだファイルは消さない
+func (p *pidFile) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.released = true
+	if p.f == nil {
+		return
+	}
+	if pid, err := readPidFile(p.path); err == nil && pid == os.Getpid() {
+		os.Remove(p.path)
+	}
+	p.f.Close()
+	p.f = nil
+}
+
+// readPidFile は path に書き込まれたプロセス ID を返す
+func readPidFile(path string) (int, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid file: %s", path)
+	}
+	return pid, nil
+}
+
+// runningPid は path に書き込まれたプロセス ID を返す。ファイルがロックされていない場合は実行中でないとみなす
+func runningPid(path string) (int, error) {
+	pid, err := readPidFile(path)
+	if err != nil {
+		return 0, err
+	}
+	locked, err := isLocked(path)
+	if err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, fmt.Errorf("stale pid file: %s", path)
+	}
+	return pid, nil
+}
@@ -0,0 +1,63 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPidFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "bragi.pid")
+
+	p := newPidFile(path)
+	if err := p.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := runningPid(path); err != nil || pid != os.Getpid() {
+		t.Fatalf("runningPid() = %d, %v", pid, err)
+	}
+
+	// アップグレード後のプロセスと同じように前のロックが解放されるまで待つ
+	acquired := make(chan error, 1)
+	next := newPidFile(path)
+	go func() { acquired <- next.acquire() }()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a locked pid file")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	p.release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pid file is not acquired after release")
+	}
+	// 前のプロセスが消したファイルではなく、新しく作ったファイルに書き込む
+	if pid, err := runningPid(path); err != nil || pid != os.Getpid() {
+		t.Fatalf("runningPid() = %d, %v", pid, err)
+	}
+
+	next.release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pid file is not removed: %v", err)
+	}
+}
+
+func TestRunningPidStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bragi.pid")
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 異常終了したプロセスが残したロックされていないファイルには送信しない
+	if _, err := runningPid(path); err == nil {
+		t.Error("stale pid file is treated as running")
+	}
+}
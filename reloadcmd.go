@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kan/bragi/config"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v3"
+)
+
+var reloadCommand = &cli.Command{
+	Name:   "reload",
+	Usage:  "実行中のSKKサーバーに設定と辞書の再読み込みを指示",
+	Action: reload,
+}
+
+func reload(ctx context.Context, cmd *cli.Command) error {
+	conf, err := config.LoadConfig(cmd.String("config"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	path, err := conf.GetPidFile()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	pid, err := runningPid(path)
+	if err != nil {
+		return errors.Wrap(err, "bragi is not running")
+	}
+	if err := sendReload(pid); err != nil {
+		return err
+	}
+
+	fmt.Printf("Sent reload signal to process %d\n", pid)
+	return nil
+}
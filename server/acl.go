@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/kan/bragi/config"
+	"github.com/pkg/errors"
+)
+
+// 接続を拒否した理由
+const (
+	RejectDenied     = "denied"
+	RejectMaxConns   = "max_conns"
+	RejectMaxConnsIP = "max_conns_per_ip"
+)
+
+var rejectReasons = []string{RejectDenied, RejectMaxConns, RejectMaxConnsIP}
+
+// accessList は接続を許可するクライアントの設定
+type accessList struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	// 0 の場合は制限しない
+	maxConns      int
+	maxConnsPerIP int
+}
+
+func parsePrefixes(ss []string) ([]netip.Prefix, error) {
+	ps := []netip.Prefix{}
+	for _, s := range ss {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		// CIDR でない場合は1つのアドレスとして扱う
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			ps = append(ps, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		ps = append(ps, p.Masked())
+	}
+	return ps, nil
+}
+
+func newAccessList(conf *config.Config) (*accessList, error) {
+	allow, err := parsePrefixes(conf.Allow)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid allow")
+	}
+	deny, err := parsePrefixes(conf.Deny)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid deny")
+	}
+	return &accessList{allow: allow, deny: deny, maxConns: conf.MaxConns, maxConnsPerIP: conf.MaxConnsPerIP}, nil
+}
+
+func containsAddr(ps []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range ps {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// permit は addr からの接続を許可するかどうかを返す。deny を allow より優先する。
+// allow が空の場合は deny に含まれないアドレスを全て許可する
+func (a *accessList) permit(addr netip.Addr) bool {
+	if containsAddr(a.deny, addr) {
+		return false
+	}
+	return len(a.allow) == 0 || containsAddr(a.allow, addr)
+}
+
+// remoteAddr は接続元の IP アドレスを返す。Unix ドメインソケットの場合は false を返す
+func remoteAddr(conn net.Conn) (netip.Addr, bool) {
+	tcp, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	addr, ok := netip.AddrFromSlice(tcp.IP)
+	return addr.Unmap(), ok
+}
+
+// connCounter は接続数と拒否した回数を数える
+type connCounter struct {
+	mu       sync.Mutex
+	total    int
+	perIP    map[netip.Addr]int
+	rejected map[string]uint64
+}
+
+// ConnStats は接続数と拒否した回数
+type ConnStats struct {
+	Active   int               `json:"active"`
+	Rejected map[string]uint64 `json:"rejected"`
+}
+
+// Admit は設定に従って接続を受け付けるかどうかを判定する。
+// 受け付けた場合は接続を閉じたときに呼ぶ関数を返す。Unix ドメインソケットは IP アドレスでの制限を行わない
+func (s *Server) Admit(conn net.Conn) (func(), error) {
+	acl := s.set.Load().acl
+	addr, isIP := remoteAddr(conn)
+
+	c := &s.conns
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reject := func(reason string) (func(), error) {
+		c.rejected[reason]++
+		return nil, fmt.Errorf("rejected: %s", reason)
+	}
+	if isIP && !acl.permit(addr) {
+		return reject(RejectDenied)
+	}
+	if acl.maxConns > 0 && c.total >= acl.maxConns {
+		return reject(RejectMaxConns)
+	}
+	if isIP && acl.maxConnsPerIP > 0 && c.perIP[addr] >= acl.maxConnsPerIP {
+		return reject(RejectMaxConnsIP)
+	}
+
+	c.total++
+	if isIP {
+		c.perIP[addr]++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.total--
+			if isIP {
+				if c.perIP[addr]--; c.perIP[addr] <= 0 {
+					delete(c.perIP, addr)
+				}
+			}
+		})
+	}, nil
+}
+
+// ConnStats は現在の接続数と拒否した回数を返す
+func (s *Server) ConnStats() ConnStats {
+	c := &s.conns
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rejected := make(map[string]uint64, len(rejectReasons))
+	for _, reason := range rejectReasons {
+		rejected[reason] = c.rejected[reason]
+	}
+	return ConnStats{Active: c.total, Rejected: rejected}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"log/slog"
+
+	"github.com/kan/bragi/config"
+	"github.com/kan/bragi/dict"
+	"github.com/kan/bragi/openai"
+	"github.com/pkg/errors"
+)
+
+// dictSet は設定から読み込んだ辞書の組。設定を変更した場合は新しく作って入れ替える。
+// 入れ替えた後、変換中のリクエストが使い終わったときに閉じる
+type dictSet struct {
+	refCount
+
+	conf  *config.Config
+	dicts []namedDict
+	// ファイルの変更時に再読み込みで入れ替えられる SKK 辞書
+	skkDicts []*skkDict
+	// 接続を許可するクライアントの設定
+	acl *accessList
+}
+
+// namedDict は変換候補の出所を示すための名前を付けた辞書。
+// SKK 辞書の場合は Dictionary に指定した値、それ以外は DictOrder で使う名前
+type namedDict struct {
+	name string
+	dict.Dict
+}
+
+// close は SKK 辞書を閉じる。最後の参照を解放したときに呼ばれる
+func (ds *dictSet) close() {
+	for _, d := range ds.skkDicts {
+		d.close()
+	}
+}
+
+// SkkDictOptions は設定から辞書 src の読み込み方法を返す
+func SkkDictOptions(conf *config.Config, src string) *dict.SkkDictOptions {
+	return &dict.SkkDictOptions{
+		Compact:  conf.CompactIndex,
+		Lenient:  conf.LenientLoad,
+		Encoding: conf.GetDictionaryOption(src).Encoding,
+	}
+}
+
+// loadDictSet は設定に従って辞書を読み込む
+func loadDictSet(conf *config.Config) (*dictSet, error) {
+	acl, err := newAccessList(conf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	dir, err := conf.GetCacheDir()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	dics := []namedDict{}
+	skds := []*skkDict{}
+	for _, name := range conf.GetDictOrder() {
+		switch name {
+		case config.DictAI:
+			if conf.UseAI {
+				ad := openai.NewOpenAIDict()
+				dics = append(dics, namedDict{name, ad})
+				slog.Info("Use dictionary", "dict", name)
+			}
+		case config.DictLisp:
+			if conf.UseLisp {
+				ld := dict.NewLispDict(conf.YearFormat, conf.MonthFormat, conf.DateFormat, conf.DateTimeFormat, conf.TimeZone)
+				dics = append(dics, namedDict{name, ld})
+				slog.Info("Use dictionary", "dict", name)
+			}
+		case config.DictCalc:
+			if conf.UseCalc {
+				cd := dict.NewCalcDict()
+				dics = append(dics, namedDict{name, cd})
+				slog.Info("Use dictionary", "dict", name)
+			}
+		case config.DictUnit:
+			if conf.UseUnit {
+				ud, err := dict.NewUnitDict()
+				if err != nil {
+					slog.Error("failed to load dictionary", "dict", name, "err", err)
+					continue
+				}
+				dics = append(dics, namedDict{name, ud})
+				slog.Info("Use dictionary", "dict", name)
+			}
+		case config.DictNumber:
+			if conf.UseNumber {
+				nd := dict.NewNumberDict()
+				dics = append(dics, namedDict{name, nd})
+				slog.Info("Use dictionary", "dict", name)
+			}
+		case config.DictAbbrev:
+			if conf.UseAbbrev {
+				bd, err := dict.NewAbbrevDict()
+				if err != nil {
+					slog.Error("failed to load dictionary", "dict", name, "err", err)
+					continue
+				}
+				dics = append(dics, namedDict{name, bd})
+				slog.Info("Use dictionary", "dict", name)
+			}
+		case config.DictEmoji:
+			if conf.UseEmoji {
+				ed, err := dict.NewEmojiDict()
+				if err != nil {
+					slog.Error("failed to load dictionary", "dict", name, "err", err)
+					continue
+				}
+				dics = append(dics, namedDict{name, ed})
+				slog.Info("Use dictionary", "dict", name)
+			}
+		case config.DictSkk:
+			for _, dic := range conf.Dictionary {
+				d := &skkDict{src: dic, dir: dir, opts: SkkDictOptions(conf, dic)}
+				skds = append(skds, d)
+				// 読み込みに失敗した場合もファイルを修正したときに読み込み直せるように残しておく
+				dics = append(dics, namedDict{dic, d})
+
+				sd, err := d.load()
+				d.setStatus(sd, err)
+				if err != nil {
+					slog.Error("failed to load dictionary", "dict", dic, "err", err)
+					continue
+				}
+				d.swap(sd)
+				slog.Info("Load dictionary", "dict", dic, "entries", sd.Len())
+				for _, w := range sd.Warnings() {
+					slog.Warn("dictionary warning", "dict", dic, "warning", w.String())
+				}
+			}
+		}
+	}
+
+	ds := &dictSet{conf: conf, dicts: dics, skkDicts: skds, acl: acl}
+	ds.init(ds.close)
+	return ds, nil
+}
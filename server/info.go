@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net"
+	"os"
+	"runtime/debug"
+
+	"github.com/kan/bragi/config"
+)
+
+// version はビルド時に -ldflags "-X github.com/kan/bragi/server.version=x.y.z" で設定するバージョン
+var version = ""
+
+// Version は Bragi のバージョンを返す。ビルド時に設定されていない場合はビルド情報から求める
+func Version() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && len(s.Value) >= 7 {
+			return "devel-" + s.Value[:7]
+		}
+	}
+	return "devel"
+}
+
+// versionResponse は「2」のリクエストに返すサーバー名とバージョン
+func versionResponse(conf *config.Config) string {
+	name := conf.ServerName
+	if name == "" {
+		name = "bragi"
+	}
+	return name + "-" + Version() + " "
+}
+
+// hostResponse は「3」のリクエストに返す「ホスト名:アドレス: 」。
+// ホスト名を設定していない場合は OS のホスト名を使う。
+// Unix ドメインソケットなど IP アドレスのない接続ではアドレスの代わりにネットワークの種類(unix など)を返す
+func hostResponse(conf *config.Config, conn net.Conn) string {
+	host := conf.ServerHost
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	addr := conn.LocalAddr().Network()
+	if tcp, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		addr = tcp.IP.String()
+	}
+	return host + ":" + addr + ": "
+}
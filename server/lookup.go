@@ -0,0 +1,113 @@
+package server
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kan/bragi/config"
+)
+
+// ReverseResult は辞書ごとの逆引きの結果
+type ReverseResult struct {
+	Name   string   `json:"name"`
+	Labels []string `json:"labels"`
+}
+
+// Reverse は候補 text を持つ見出し語を SKK 辞書ごとに返す。見つからなかった辞書は含めない
+func (s *Server) Reverse(text string) []ReverseResult {
+	set := s.acquireSet()
+	defer set.release()
+
+	rs := []ReverseResult{}
+	for _, d := range set.skkDicts {
+		ld := d.acquire()
+		if ld == nil {
+			continue
+		}
+		if ls := ld.Reverse(text); len(ls) > 0 {
+			rs = append(rs, ReverseResult{Name: d.src, Labels: ls})
+		}
+		ld.release()
+	}
+	return rs
+}
+
+// Candidate は変換候補と、それを返した辞書の名前
+type Candidate struct {
+	Text   string `json:"text"`
+	Desc   string `json:"desc"`
+	Source string `json:"source"`
+	// 辞書が返した形式のままの候補
+	raw string
+}
+
+// DictResult は辞書ごとの変換結果
+type DictResult struct {
+	Name       string      `json:"name"`
+	Candidates []Candidate `json:"candidates"`
+	// Elapsed は変換にかかった時間(ミリ秒)
+	Elapsed float64 `json:"elapsed_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// LookupResult は全ての辞書での変換結果
+type LookupResult struct {
+	Query string `json:"query"`
+	// Candidates は SKK クライアントに返す順番で重複を取り除いた候補。同じ候補は最初に返した辞書のものを使う
+	Candidates []Candidate  `json:"candidates"`
+	Dicts      []DictResult `json:"dicts"`
+}
+
+// lookup は set の全ての辞書で text を変換する。ai が false の場合は AI 辞書を使わない
+func lookup(set *dictSet, text string, ai bool) *LookupResult {
+	res := &LookupResult{Query: text, Candidates: []Candidate{}, Dicts: []DictResult{}}
+	seen := map[string]bool{}
+	for _, dic := range set.dicts {
+		if !ai && dic.name == config.DictAI {
+			continue
+		}
+		start := time.Now()
+		ws, err := dic.Convert(text)
+		dr := DictResult{Name: dic.name, Candidates: []Candidate{}, Elapsed: float64(time.Since(start)) / float64(time.Millisecond)}
+		if err != nil {
+			dr.Error = err.Error()
+			res.Dicts = append(res.Dicts, dr)
+			continue
+		}
+
+		for _, w := range ws {
+			t, desc, _ := strings.Cut(w, ";")
+			c := Candidate{Text: t, Desc: desc, Source: dic.name, raw: w}
+			dr.Candidates = append(dr.Candidates, c)
+			// 複数の辞書から同じ候補が返る場合は最初のものだけを使う
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+			res.Candidates = append(res.Candidates, c)
+		}
+		res.Dicts = append(res.Dicts, dr)
+	}
+	return res
+}
+
+// Lookup は全ての辞書で text を変換し、辞書ごとの結果を返す。
+// AI 辞書は問い合わせごとに料金がかかるため、ai が true の場合だけ使う
+func (s *Server) Lookup(text string, ai bool) *LookupResult {
+	set := s.acquireSet()
+	defer set.release()
+	return lookup(set, text, ai)
+}
+
+// wire は SKK クライアントに返す形式の候補を返す。annotate の場合は注釈に辞書の名前を付ける
+func (c Candidate) wire(annotate bool) string {
+	if !annotate {
+		return c.raw
+	}
+	src := "[" + path.Base(c.Source) + "]"
+	if c.Desc == "" {
+		return c.Text + ";" + src
+	}
+	return c.Text + ";" + c.Desc + " " + src
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kan/bragi/config"
+)
+
+// TestLookupDedup は複数の辞書が返す同じ候補を最初の辞書のものだけにすることを確かめる
+func TestLookupDedup(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.dic")
+	second := filepath.Join(dir, "second.dic")
+	if err := os.WriteFile(second, []byte(";; okuri-nasi entries.\nかんじ /感じ;second/幹事/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, func(conf *config.Config) {
+		if err := os.Rename(conf.Dictionary[0], first); err != nil {
+			t.Fatal(err)
+		}
+		conf.Dictionary = []string{first, second}
+	})
+
+	res := s.Lookup("かんじ", false)
+	got := []Candidate{}
+	for _, c := range res.Candidates {
+		got = append(got, Candidate{Text: c.Text, Desc: c.Desc, Source: c.Source})
+	}
+	want := []Candidate{
+		{Text: "漢字", Source: first},
+		{Text: "感じ", Desc: "feeling", Source: first},
+		{Text: "幹事", Source: second},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Candidates = %+v, want %+v", got, want)
+	}
+
+	// 辞書ごとの結果には重複した候補も含める
+	if len(res.Dicts) != 2 || len(res.Dicts[1].Candidates) != 2 {
+		t.Errorf("Dicts = %+v", res.Dicts)
+	}
+
+	// SKK クライアントにも重複を取り除いた候補を返す
+	if out := string(exchange(t, s, []byte("1かんじ "))); out != "1/漢字;/感じ;feeling/幹事;/\n" {
+		t.Errorf("response = %q", out)
+	}
+}
+
+// TestLookupWithoutAI は明示しない限り AI 辞書を使わないことを確かめる
+func TestLookupWithoutAI(t *testing.T) {
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.UseAI = true
+		conf.DictOrder = []string{config.DictAI, config.DictSkk}
+	})
+
+	res := s.Lookup("かんじ", false)
+	for _, dr := range res.Dicts {
+		if dr.Name == config.DictAI {
+			t.Errorf("AI dictionary is used: %+v", dr)
+		}
+	}
+	if len(res.Dicts) != 1 || len(res.Candidates) != 2 {
+		t.Errorf("Lookup = %+v", res)
+	}
+}
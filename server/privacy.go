@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+
+	"github.com/kan/bragi/config"
+)
+
+// ログに出力する見出し語と変換候補の扱い
+const (
+	LogPrivacyOff  = "off"
+	LogPrivacyHash = "hash"
+	LogPrivacyOmit = "omit"
+)
+
+// privacyKey は見出し語のハッシュ値を求める鍵。起動ごとに変わるため、同じ起動中のログでだけ同じ見出し語を見分けられる
+var privacyKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// wordAttr は設定に従ってログに出力する見出し語を返す
+func wordAttr(conf *config.Config, text string) slog.Attr {
+	switch conf.LogPrivacy {
+	case LogPrivacyOff:
+		return slog.String("word", text)
+	case LogPrivacyOmit:
+		return slog.Attr{}
+	default:
+		mac := hmac.New(sha256.New, privacyKey)
+		mac.Write([]byte(text))
+		return slog.String("word_hash", hex.EncodeToString(mac.Sum(nil))[:16])
+	}
+}
+
+// candidatesAttr は設定に従ってログに出力する変換候補を返す。隠す場合は数だけを返す
+func candidatesAttr(conf *config.Config, words []string) slog.Attr {
+	if conf.LogPrivacy == LogPrivacyOff {
+		return slog.Any("kanji", words)
+	}
+	return slog.Int("candidates", len(words))
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"sync/atomic"
+)
+
+// refCount は参照数を数え、最後の参照を解放したときに done を呼ぶ。作った時点で所有者の参照を1つ持つ
+type refCount struct {
+	n    atomic.Int64
+	done func()
+}
+
+func (r *refCount) init(done func()) {
+	r.n.Store(1)
+	r.done = done
+}
+
+// acquire は参照を1つ増やす。既に全ての参照が解放されている場合は false を返す
+func (r *refCount) acquire() bool {
+	for {
+		n := r.n.Load()
+		if n <= 0 {
+			return false
+		}
+		if r.n.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+func (r *refCount) release() {
+	if r.n.Add(-1) == 0 && r.done != nil {
+		r.done()
+	}
+}
+
+// acquirePointer は p が指す値の参照を1つ増やして返す。
+// 読み込んでから参照を増やすまでの間に入れ替えられて解放された場合は読み込み直す。p が nil の場合は nil を返す
+func acquirePointer[T any, PT interface {
+	*T
+	acquire() bool
+}](p *atomic.Pointer[T]) PT {
+	for {
+		v := PT(p.Load())
+		if v == nil || v.acquire() {
+			return v
+		}
+	}
+}
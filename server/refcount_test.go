@@ -0,0 +1,89 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type testRef struct {
+	refCount
+	closed atomic.Bool
+}
+
+func newTestRef() *testRef {
+	r := &testRef{}
+	r.init(func() { r.closed.Store(true) })
+	return r
+}
+
+func TestRefCountReleaseAfterSwap(t *testing.T) {
+	var p atomic.Pointer[testRef]
+	old := newTestRef()
+	p.Store(old)
+
+	// 変換中のリクエストが古い値を使っている間に入れ替える
+	inUse := acquirePointer(&p)
+	if inUse != old {
+		t.Fatal("acquirePointer returns another value")
+	}
+	p.Swap(newTestRef()).release()
+	if old.closed.Load() {
+		t.Fatal("closed while in use")
+	}
+	inUse.release()
+	if !old.closed.Load() {
+		t.Fatal("not closed after the last release")
+	}
+
+	// 解放済みの値は参照できない
+	if old.acquire() {
+		t.Fatal("acquired a released value")
+	}
+	if cur := acquirePointer(&p); cur == old || cur == nil {
+		t.Fatalf("acquirePointer() = %p", cur)
+	} else {
+		cur.release()
+	}
+}
+
+func TestRefCountConcurrentSwap(t *testing.T) {
+	var p atomic.Pointer[testRef]
+	p.Store(newTestRef())
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				r := acquirePointer(&p)
+				if r.closed.Load() {
+					t.Error("acquired a closed value")
+				}
+				r.release()
+			}
+		}()
+	}
+
+	olds := []*testRef{}
+	for i := 0; i < 1000; i++ {
+		old := p.Swap(newTestRef())
+		olds = append(olds, old)
+		old.release()
+	}
+	close(stop)
+	wg.Wait()
+
+	for _, old := range olds {
+		if !old.closed.Load() {
+			t.Fatal("swapped value is not closed")
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kan/bragi/dict"
+	"github.com/pkg/errors"
+)
+
+const (
+	// 辞書ファイルの変更を検知してから読み込むまでの待ち時間。保存中の連続した書き込みをまとめる
+	reloadDelay = 500 * time.Millisecond
+	// 保持する再読み込みの履歴の数
+	maxReloadEvents = 100
+)
+
+// ReloadEvent は辞書ファイルの再読み込みの結果
+type ReloadEvent struct {
+	Name    string    `json:"name"`
+	Time    time.Time `json:"time"`
+	Entries int       `json:"entries"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// loadedDict は読み込んだ SKK 辞書。入れ替えた後、変換中のリクエストが使い終わったときに閉じる
+type loadedDict struct {
+	*dict.SkkDict
+	refCount
+}
+
+func newLoadedDict(sd *dict.SkkDict) *loadedDict {
+	ld := &loadedDict{SkkDict: sd}
+	ld.init(func() { sd.Close() })
+	return ld
+}
+
+// skkDict は再読み込みで入れ替えられる SKK 辞書
+type skkDict struct {
+	src  string
+	dir  string
+	opts *dict.SkkDictOptions
+	sd   atomic.Pointer[loadedDict]
+	// 読み込み結果
+	status atomic.Pointer[DictStatus]
+	// 同じ辞書の読み込みが同時に行われないようにする
+	mu sync.Mutex
+	// 設定の変更で使われなくなった場合は再読み込みしない
+	closed bool
+}
+
+// acquire は現在の辞書を返す。使い終わったら release を呼ぶ。読み込めていない場合は nil を返す
+func (d *skkDict) acquire() *loadedDict {
+	return acquirePointer(&d.sd)
+}
+
+// swap は辞書を入れ替え、古い辞書の所有者の参照を解放する
+func (d *skkDict) swap(sd *dict.SkkDict) {
+	var ld *loadedDict
+	if sd != nil {
+		ld = newLoadedDict(sd)
+	}
+	if old := d.sd.Swap(ld); old != nil {
+		old.release()
+	}
+}
+
+func (d *skkDict) Convert(word string) ([]string, error) {
+	ld := d.acquire()
+	if ld == nil {
+		return []string{}, nil
+	}
+	defer ld.release()
+	return ld.Convert(word)
+}
+
+func (d *skkDict) load() (*dict.SkkDict, error) {
+	sd, _, err := dict.NewSkkDictWithOptions(d.src, d.dir, false, d.opts)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return sd, nil
+}
+
+func (d *skkDict) setStatus(sd *dict.SkkDict, err error) {
+	if err != nil {
+		d.status.Store(&DictStatus{Name: d.src, Warnings: []dict.Warning{}, Error: err.Error()})
+		return
+	}
+	d.status.Store(&DictStatus{Name: d.src, Entries: sd.Len(), Warnings: sd.Warnings()})
+}
+
+// close は再読み込みをやめて辞書を解放する。変換中のリクエストがある場合は使い終わったときに閉じる
+func (d *skkDict) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	d.swap(nil)
+}
+
+// reload は辞書を読み込み直して入れ替える。読み込みに失敗した場合は元の辞書を使い続ける
+func (s *Server) reload(d *skkDict) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+
+	ev := ReloadEvent{Name: d.src, Time: time.Now()}
+	sd, err := d.load()
+	if err == nil && sd.Len() == 0 {
+		// 保存途中のファイルを読み込んだ可能性があるため、空の辞書には入れ替えない
+		if old := d.acquire(); old != nil {
+			if old.Len() > 0 {
+				err = fmt.Errorf("dictionary is empty: %s", d.src)
+			}
+			old.release()
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to reload dictionary", "dict", d.src, "err", err)
+		ev.Error = err.Error()
+		// 辞書は元のまま使い続けるが、管理画面で失敗に気付けるように状態には記録する
+		d.setStatus(nil, err)
+	} else {
+		slog.Info("Reload dictionary", "dict", d.src, "entries", sd.Len())
+		for _, w := range sd.Warnings() {
+			slog.Warn("dictionary warning", "dict", d.src, "warning", w.String())
+		}
+		ev.Entries = sd.Len()
+		d.setStatus(sd, nil)
+		d.swap(sd)
+	}
+
+	s.addEvent(ev)
+}
+
+func (s *Server) addEvent(ev ReloadEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+	if len(s.events) > maxReloadEvents {
+		s.events = s.events[len(s.events)-maxReloadEvents:]
+	}
+}
+
+// RecordConfigReload は設定ファイルの再読み込みの結果を履歴に記録する
+func (s *Server) RecordConfigReload(path string, err error) {
+	ev := ReloadEvent{Name: path, Time: time.Now()}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	s.addEvent(ev)
+}
+
+// Watch はローカルの辞書ファイルの変更を監視し、変更された辞書を読み込み直す。
+// Update で辞書の組を入れ替えた場合は新しい辞書の組を監視する。ctx が終了するまで戻らない
+func (s *Server) Watch(ctx context.Context) error {
+	for {
+		wctx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func(set *dictSet) {
+			done <- s.watchSet(wctx, set)
+		}(s.set.Load())
+
+		select {
+		case <-ctx.Done():
+			cancel()
+			<-done
+			return nil
+		case <-s.updated:
+			cancel()
+			<-done
+		case err := <-done:
+			cancel()
+			if err != nil {
+				slog.Error("failed to watch dictionaries", "err", err)
+			}
+			// 監視する辞書がない場合も辞書の組が入れ替わるのを待つ
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.updated:
+			}
+		}
+	}
+}
+
+func (s *Server) watchSet(ctx context.Context, set *dictSet) error {
+	targets := map[string][]*skkDict{}
+	for _, d := range set.skkDicts {
+		fpath, ok := dict.LocalPath(d.src)
+		if !ok {
+			continue
+		}
+		abs, err := filepath.Abs(fpath)
+		if err != nil {
+			slog.Warn("failed to watch dictionary", "dict", d.src, "err", err)
+			continue
+		}
+		targets[abs] = append(targets[abs], d)
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer w.Close()
+
+	// エディタは別名で保存してから置き換えることがあるため、ファイルではなくディレクトリを監視する
+	dirs := map[string]bool{}
+	for fpath := range targets {
+		dir := filepath.Dir(fpath)
+		if dirs[dir] {
+			continue
+		}
+		if err := w.Add(dir); err != nil {
+			slog.Warn("Failed to watch", "dir", dir, "err", err)
+			continue
+		}
+		dirs[dir] = true
+	}
+
+	timers := map[string]*time.Timer{}
+	defer func() {
+		for _, t := range timers {
+			t.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			fpath := filepath.Clean(ev.Name)
+			ds, ok := targets[fpath]
+			if !ok {
+				continue
+			}
+			if t, ok := timers[fpath]; ok {
+				t.Reset(reloadDelay)
+				continue
+			}
+			timers[fpath] = time.AfterFunc(reloadDelay, func() {
+				for _, d := range ds {
+					s.reload(d)
+				}
+			})
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Warn("watch error", "err", err)
+		}
+	}
+}
+
+// ReloadEvents は辞書ファイルの再読み込みの履歴を返す
+func (s *Server) ReloadEvents() []ReloadEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ReloadEvent{}, s.events...)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rewriteAndWait は辞書ファイルを書き換え、再読み込みの履歴が増えるまで待つ。
+// 監視を始める前に書き換えた場合に備えて、一定時間ごとに書き直す
+func rewriteAndWait(t *testing.T, s *Server, path, content string) ReloadEvent {
+	t.Helper()
+	n := len(s.ReloadEvents())
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		retry := time.Now().Add(reloadDelay * 3)
+		for time.Now().Before(retry) {
+			if evs := s.ReloadEvents(); len(evs) > n {
+				return evs[len(evs)-1]
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	t.Fatalf("dictionary is not reloaded: %s", path)
+	return ReloadEvent{}
+}
+
+func TestWatchReload(t *testing.T) {
+	s := newTestServer(t, nil)
+	path := s.Config().Dictionary[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Watch(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	ev := rewriteAndWait(t, s, path, ";; okuri-nasi entries.\nかんじ /幹事/\n")
+	if ev.Error != "" {
+		t.Fatalf("reload failed: %s", ev.Error)
+	}
+	if got := s.handle("かんじ"); !strings.Contains(got, "幹事") {
+		t.Errorf("lookup after reload = %q, want 幹事", got)
+	}
+	if st := s.DictStatus()[0]; st.Error != "" || st.Entries != 1 {
+		t.Errorf("status after reload = %+v", st)
+	}
+
+	// 読み込めないファイルに書き換えた場合は元の辞書を使い続け、状態に失敗を記録する
+	ev = rewriteAndWait(t, s, path, ";; okuri-nasi entries.\nかんじ\n")
+	if ev.Error == "" {
+		t.Fatal("reload of a broken dictionary succeeded")
+	}
+	if got := s.handle("かんじ"); !strings.Contains(got, "幹事") {
+		t.Errorf("lookup after failed reload = %q, want 幹事", got)
+	}
+	if st := s.DictStatus()[0]; st.Error != ev.Error {
+		t.Errorf("status error = %q, want %q", st.Error, ev.Error)
+	}
+}
@@ -2,101 +2,256 @@ package server
 
 import (
 	"bufio"
-	"log"
+	"io"
+	"log/slog"
 	"net"
+	"net/netip"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/kan/bragi/config"
 	"github.com/kan/bragi/dict"
-	"github.com/kan/bragi/openai"
 	"github.com/pkg/errors"
 )
 
 type Server struct {
-	Config *config.Config
-	Dicts  []dict.Dict
+	// 変換に使う辞書の組。処理中のリクエストは入れ替え前の辞書の組で最後まで処理する。
+	// 辞書を使う場合は acquireSet で参照を増やす
+	set atomic.Pointer[dictSet]
+	// set を入れ替えたことを Watch に知らせる
+	updated chan struct{}
+
+	mu     sync.Mutex
+	events []ReloadEvent
+
+	// 接続数と拒否した回数
+	conns connCounter
+
+	// 処理中の接続とその状態
+	liveMu  sync.Mutex
+	live    map[net.Conn]liveConn
+	closing bool
+}
+
+// DictStatus は辞書ファイルの読み込み結果
+type DictStatus struct {
+	Name     string         `json:"name"`
+	Entries  int            `json:"entries"`
+	Warnings []dict.Warning `json:"warnings"`
+	Error    string         `json:"error,omitempty"`
 }
 
+// DictStatus は辞書ファイルの読み込み結果を返す
+func (s *Server) DictStatus() []DictStatus {
+	sts := []DictStatus{}
+	for _, d := range s.set.Load().skkDicts {
+		sts = append(sts, *d.status.Load())
+	}
+	return sts
+}
+
+// Config は現在の辞書の組を読み込んだ設定を返す
+func (s *Server) Config() *config.Config {
+	return s.set.Load().conf
+}
+
+// errMidashiTooLong は見出し語が設定の最大の長さを超えたことを表す
+var errMidashiTooLong = errors.New("midashi too long")
+
+// readMidashi は空白か改行までを見出し語として読む。改行の前の CR は取り除く。
+// 見出し語が limit バイトを超える場合は区切りまで読み捨てて errMidashiTooLong を返す
+func readMidashi(r *bufio.Reader, limit int) (string, error) {
+	buf := []byte{}
+	over := false
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c == ' ' || c == '\n' {
+			break
+		}
+		if over {
+			continue
+		}
+		if limit > 0 && len(buf) >= limit {
+			over = true
+			buf = nil
+			continue
+		}
+		buf = append(buf, c)
+	}
+	if over {
+		return "", errMidashiTooLong
+	}
+	return strings.TrimSuffix(string(buf), "\r"), nil
+}
+
+// setDeadline は d 後を期限に設定する。d が 0 の場合は期限をなくす
+func setDeadline(set func(time.Time) error, d time.Duration) {
+	if d <= 0 {
+		set(time.Time{})
+		return
+	}
+	set(time.Now().Add(d))
+}
+
+// Serve は SKK サーバーのプロトコルで接続を処理する。
+// 応答は変換候補がある場合に「1」、ない場合に「4」、解釈できないリクエストには「0」を返す
 func (s *Server) Serve(conn net.Conn) {
 	defer conn.Close()
+	s.track(conn)
+	defer s.untrack(conn)
+
 	r := bufio.NewReader(conn)
 	for {
+		conf := s.Config()
+		idle, read, write := conf.GetTimeouts()
+
+		if !s.setState(conn, connIdle) {
+			return
+		}
+		setDeadline(conn.SetReadDeadline, idle)
 		c, err := r.ReadByte()
 		if err != nil {
-			log.Println("Error reading from connection: ", err)
+			// 終了処理で読み込みを打ち切った場合は警告しない
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !(errors.Is(err, os.ErrDeadlineExceeded) && s.isClosing()) {
+				slog.Warn("Error reading from connection", "remote", conn.RemoteAddr(), "err", err)
+			}
 			return
 		}
+		// リクエストの間の空白や改行は読み飛ばす
+		if c == ' ' || c == '\r' || c == '\n' {
+			continue
+		}
+
+		// closeIdle が読み込みを打ち切らないように処理中にしてから、読み込みの期限を設定し直す
+		s.setState(conn, connBusy)
+		setDeadline(conn.SetReadDeadline, read)
+		var res string
 		switch c {
 		case '0':
 			return
-		case '1':
-			buf, err := r.ReadBytes(' ')
+		case '1', '4':
+			text, err := readMidashi(r, conf.MaxMidashiLen)
+			if errors.Is(err, errMidashiTooLong) {
+				slog.Warn("midashi too long", "remote", conn.RemoteAddr(), "max", conf.MaxMidashiLen)
+				res = "4\n"
+				break
+			}
 			if err != nil {
+				slog.Warn("Error reading from connection", "remote", conn.RemoteAddr(), "err", err)
 				return
 			}
-			if err := s.handle(conn, buf); err != nil {
-				return
+			if c == '1' {
+				res = s.handle(text)
+			} else {
+				// 補完には対応していないため候補がないことを返す
+				res = "4" + text + " \n"
 			}
 		case '2':
-			conn.Write([]byte("0.0.1 "))
+			res = versionResponse(conf)
 		case '3':
-			addr := conn.LocalAddr().String()
-			conn.Write([]byte(addr + " "))
+			res = hostResponse(conf, conn)
 		default:
-			log.Print(c)
+			slog.Warn("unknown command", "remote", conn.RemoteAddr(), "command", string(c))
+			if _, err := readMidashi(r, conf.MaxMidashiLen); err != nil && !errors.Is(err, errMidashiTooLong) {
+				return
+			}
+			res = "0\n"
+		}
+
+		setDeadline(conn.SetWriteDeadline, write)
+		if _, err := conn.Write([]byte(res)); err != nil {
+			slog.Warn("Error writing to connection", "remote", conn.RemoteAddr(), "err", err)
+			return
 		}
 	}
 }
 
-func (s *Server) handle(conn net.Conn, buf []byte) error {
-	text := string(buf[:len(buf)-1])
-	log.Println("word: " + text)
+// acquireSet は現在の辞書の組を返す。使い終わったら release を呼ぶ
+func (s *Server) acquireSet() *dictSet {
+	return acquirePointer(&s.set)
+}
 
-	words := []string{}
-	for _, dic := range s.Dicts {
-		ws, err := dic.Convert(text)
-		if err == nil {
-			words = append(words, ws...)
-		}
+// handle は見出し語を変換して応答を返す
+func (s *Server) handle(text string) string {
+	set := s.acquireSet()
+	defer set.release()
+	res := lookup(set, text, true)
+	words := make([]string, len(res.Candidates))
+	for i, c := range res.Candidates {
+		words[i] = c.wire(set.conf.AnnotateSource)
 	}
 
-	log.Printf("kanji: %v", words)
-	conn.Write([]byte("1/" + strings.Join(words, "/") + "/\n"))
-	return nil
+	// 見出し語と変換候補は設定に従って隠す
+	slog.Debug("convert", wordAttr(set.conf, text), candidatesAttr(set.conf, words))
+	if len(words) == 0 {
+		return "4" + text + " \n"
+	}
+	return "1/" + strings.Join(words, "/") + "/\n"
 }
 
 func LoadServer(conf *config.Config) (*Server, error) {
-	log.Printf("Bragi server is running on port %s\n", conf.Port)
+	set, err := loadDictSet(conf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-	dics := []dict.Dict{}
-	if conf.UseAI {
-		ad := openai.NewOpenAIDict()
-		dics = append(dics, ad)
-		log.Printf("Use AI Dictionary\n")
+	s := &Server{updated: make(chan struct{}, 1), live: map[net.Conn]liveConn{}}
+	s.conns.perIP = map[netip.Addr]int{}
+	s.conns.rejected = map[string]uint64{}
+	s.set.Store(set)
+
+	return s, nil
+}
+
+// ValidateConfig は辞書を読み込まずに確かめられる設定の誤りを返す。
+// 管理画面から保存する前に確かめ、再読み込みや次の起動で失敗する設定を書き込まないようにする
+func ValidateConfig(conf *config.Config) error {
+	if _, err := newAccessList(conf); err != nil {
+		return err
 	}
-	if conf.UseLisp {
-		ld := dict.NewLispDict(conf.YearFormat, conf.MonthFormat, conf.DateFormat, conf.DateTimeFormat, conf.TimeZone)
-		dics = append(dics, ld)
-		log.Printf("Use Lisp Dictionary\n")
+	if _, err := conf.GetSocketMode(); err != nil {
+		return errors.WithStack(err)
 	}
+	if _, err := conf.GetLogLevel(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// PendingUpdate は読み込んだが、まだ変換に使っていない辞書の組
+type PendingUpdate struct {
+	s   *Server
+	set *dictSet
+}
 
-	dir, err := conf.GetCacheDir()
+// Prepare は新しい設定で辞書を読み込む。Commit で入れ替えるまで変換には使わない。
+// 待ち受け直しなど他の変更に失敗した場合は Discard で破棄する
+func (s *Server) Prepare(conf *config.Config) (*PendingUpdate, error) {
+	set, err := loadDictSet(conf)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	return &PendingUpdate{s: s, set: set}, nil
+}
 
-	for _, dic := range conf.Dictionary {
-		sd, _, err := dict.NewSkkDict(dic, dir, false)
-		if err != nil {
-			log.Printf("%v", err)
-			continue
-		}
-		log.Printf("Load dictionary: %s ...\n", dic)
+// Commit は読み込んだ辞書の組に入れ替える。待ち受けているポートはそのまま使う
+func (p *PendingUpdate) Commit() {
+	old := p.s.set.Swap(p.set)
+	old.release()
 
-		dics = append(dics, sd)
+	select {
+	case p.s.updated <- struct{}{}:
+	default:
 	}
+}
 
-	s := &Server{Config: conf, Dicts: dics}
-
-	return s, nil
+// Discard は読み込んだ辞書の組を使わずに閉じる
+func (p *PendingUpdate) Discard() {
+	p.set.release()
 }
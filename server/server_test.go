@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kan/bragi/config"
+)
+
+const testDict = `;; okuri-nasi entries.
+かんじ /漢字/感じ;feeling/
+へんかん /変換/
+`
+
+// newTestServer は小さな SKK 辞書だけを使うサーバーを作る。change で設定を変更できる
+func newTestServer(t testing.TB, change func(conf *config.Config)) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	dic := filepath.Join(dir, "test.dic")
+	if err := os.WriteFile(dic, []byte(testDict), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{
+		Dictionary:    []string{dic},
+		DictPath:      dir,
+		DictOrder:     []string{config.DictSkk},
+		ServerName:    "bragi",
+		ServerHost:    "skk.test",
+		MaxMidashiLen: 16,
+		LogPrivacy:    LogPrivacyOmit,
+	}
+	if change != nil {
+		change(conf)
+	}
+	s, err := LoadServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// exchange は net.Pipe で Serve に input を送り、接続が閉じられるまでの応答を返す
+func exchange(t testing.TB, s *Server, input []byte) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Serve(server)
+	}()
+
+	// net.Pipe はバッファを持たないため、応答を読みながら書き込む
+	go func() {
+		client.Write(input)
+		// 途中の見出し語を区切ってから切断する
+		client.Write([]byte(" 0"))
+	}()
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	out, err := io.ReadAll(client)
+	client.Close()
+	if err != nil && !isClosed(err) {
+		t.Fatalf("read: %v (input %q)", err, input)
+	}
+	<-done
+	return out
+}
+
+func isClosed(err error) bool {
+	return err == io.ErrClosedPipe || err == io.EOF
+}
+
+// checkResponses は応答が SKK サーバーのプロトコルの形式になっているかを確かめる
+func checkResponses(t testing.TB, s *Server, out []byte) {
+	t.Helper()
+	conf := s.Config()
+	version := versionResponse(conf)
+	host := "skk.test:pipe: "
+
+	rest := string(out)
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, version):
+			rest = rest[len(version):]
+		case strings.HasPrefix(rest, host):
+			rest = rest[len(host):]
+		case strings.HasPrefix(rest, "0\n"):
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "1/"):
+			line, after, ok := strings.Cut(rest, "\n")
+			if !ok || !strings.HasSuffix(line, "/") {
+				t.Fatalf("broken candidates: %q", rest)
+			}
+			rest = after
+		case strings.HasPrefix(rest, "4"):
+			line, after, ok := strings.Cut(rest, "\n")
+			if !ok {
+				t.Fatalf("broken response: %q", rest)
+			}
+			if line != "4" {
+				text, ok := strings.CutSuffix(line[1:], " ")
+				if !ok {
+					t.Fatalf("broken response: %q", line)
+				}
+				if len(text) > conf.MaxMidashiLen {
+					t.Fatalf("midashi longer than max_midashi_len is accepted: %q", text)
+				}
+			}
+			rest = after
+		default:
+			t.Fatalf("unexpected response: %q", rest)
+		}
+	}
+}
+
+func FuzzServe(f *testing.F) {
+	for _, seed := range []string{
+		"1かんじ ",
+		"1かんじ\r\n1へんかん\n",
+		"2 3 ",
+		"4かん ",
+		"1" + strings.Repeat("あ", 10) + " 1かんじ ",
+		"x",
+		"0",
+		"\r\n \n",
+		"1\xff\xfe ",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	s := newTestServer(f, nil)
+	f.Fuzz(func(t *testing.T, input []byte) {
+		checkResponses(t, s, exchange(t, s, input))
+	})
+}
+
+// transcriptStep は記録したやりとりの1行。send が true の場合はクライアントが送信する
+type transcriptStep struct {
+	send bool
+	data string
+}
+
+func readTranscript(t *testing.T, path string) []transcriptStep {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	steps := []transcriptStep{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dir, quoted, ok := strings.Cut(line, " ")
+		data, err := strconv.Unquote(quoted)
+		if !ok || err != nil || dir != ">" && dir != "<" {
+			t.Fatalf("%s:%d: invalid line: %s", path, n, line)
+		}
+		steps = append(steps, transcriptStep{send: dir == ">", data: data})
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return steps
+}
+
+// TestServeTranscripts は testdata/transcripts に記録したやりとりを再生する。最後は接続が閉じられることを確かめる
+func TestServeTranscripts(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	version = "test"
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "transcripts", "*.txt"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no transcripts: %v", err)
+	}
+	s := newTestServer(t, nil)
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				s.Serve(server)
+			}()
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+
+			for _, st := range readTranscript(t, path) {
+				if st.send {
+					if _, err := client.Write([]byte(st.data)); err != nil {
+						t.Fatalf("write %q: %v", st.data, err)
+					}
+					continue
+				}
+				buf := make([]byte, len(st.data))
+				if _, err := io.ReadFull(client, buf); err != nil {
+					t.Fatalf("read %q: %v", st.data, err)
+				}
+				if string(buf) != st.data {
+					t.Fatalf("got %q, want %q", buf, st.data)
+				}
+			}
+
+			if n, err := client.Read(make([]byte, 1)); n != 0 || !isClosed(err) {
+				t.Errorf("connection is not closed: %d, %v", n, err)
+			}
+			<-done
+		})
+	}
+}
+
+// TestHostResponse は実際の待ち受けでホスト名の問い合わせに返すアドレスを確かめる
+func TestHostResponse(t *testing.T) {
+	s := newTestServer(t, nil)
+	tests := []struct {
+		network, addr, want string
+	}{
+		{"tcp", "127.0.0.1:0", "skk.test:127.0.0.1: "},
+		{"unix", filepath.Join(t.TempDir(), "skk.sock"), "skk.test:unix: "},
+	}
+
+	for _, tt := range tests {
+		l, err := net.Listen(tt.network, tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			conn, err := l.Accept()
+			if err == nil {
+				s.Serve(conn)
+			}
+		}()
+
+		conn, err := net.Dial(tt.network, l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("3"))
+		buf := make([]byte, len(tt.want))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("%s: %v", tt.network, err)
+		}
+		if string(buf) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.network, buf, tt.want)
+		}
+		conn.Write([]byte("0"))
+		conn.Close()
+		l.Close()
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// 終了時に接続が閉じられたかどうかを確認する間隔
+	shutdownPollInterval = 100 * time.Millisecond
+	// 終了時にまだ最初のリクエストを受け取っていない接続を待つ時間。
+	// 終了の直前に受け付けた接続のリクエストが届く前に閉じないようにする
+	shutdownNewConnGrace = 5 * time.Second
+)
+
+// connState は接続の状態
+type connState int
+
+const (
+	// 接続してからまだリクエストを受け取っていない
+	connNew connState = iota
+	// 次のリクエストを待っている
+	connIdle
+	// リクエストを処理中
+	connBusy
+)
+
+type liveConn struct {
+	state connState
+	since time.Time
+}
+
+// track は接続を登録する。終了処理中に受け付けた接続も最初のリクエストは処理する
+func (s *Server) track(conn net.Conn) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	s.live[conn] = liveConn{state: connNew, since: time.Now()}
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	delete(s.live, conn)
+}
+
+// setState は接続の状態を記録する。終了処理中に次のリクエストを待とうとした場合は false を返す
+func (s *Server) setState(conn net.Conn, state connState) bool {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	if c := s.live[conn]; c.state == connNew && state == connIdle {
+		// 最初のリクエストを受け取るまでは新しい接続として扱う
+		return true
+	}
+	if s.closing && state == connIdle {
+		return false
+	}
+	s.live[conn] = liveConn{state: state, since: time.Now()}
+	return true
+}
+
+func (s *Server) isClosing() bool {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	return s.closing
+}
+
+// closeIdle はリクエストを待っている接続と、shutdownNewConnGrace の間リクエストが届かない新しい接続の読み込みを打ち切る。
+// 直接閉じると読み込んだばかりのリクエストに応答できなくなるため、読み込みの期限を過ぎさせて Serve に閉じさせる。
+// Serve はリクエストを読み込むと期限を設定し直すので、その前に処理中になった接続は打ち切られない
+func (s *Server) closeIdle() {
+	s.liveMu.Lock()
+	defer s.liveMu.Unlock()
+	for conn, c := range s.live {
+		if c.state == connIdle || c.state == connNew && time.Since(c.since) >= shutdownNewConnGrace {
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+}
+
+// Shutdown は次のリクエストの受け付けを止め、リクエストを待っている接続を閉じる。
+// 処理中のリクエストと新しい接続の最初のリクエストは終わるまで待ち、ctx が終了した場合は残りの接続を閉じる
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.liveMu.Lock()
+	s.closing = true
+	s.liveMu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		s.closeIdle()
+		s.liveMu.Lock()
+		n := len(s.live)
+		s.liveMu.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			s.liveMu.Lock()
+			for conn := range s.live {
+				conn.Close()
+			}
+			s.liveMu.Unlock()
+			return errors.WithStack(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readHookConn は n 回目の Read でデータを読んだ直後に hook を呼ぶ
+type readHookConn struct {
+	net.Conn
+	n     int
+	reads int
+	hook  func()
+}
+
+func (c *readHookConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.reads++
+	if c.reads == c.n && n > 0 {
+		c.hook()
+	}
+	return n, err
+}
+
+// TestShutdownConcurrentRequest はリクエストを読み込んだ直後に終了処理が始まっても、そのリクエストに応答することを確かめる
+func TestShutdownConcurrentRequest(t *testing.T) {
+	s := newTestServer(t, nil)
+	client, server := net.Pipe()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	conn := &readHookConn{Conn: server, n: 2, hook: func() {
+		// 接続がまだリクエストを待っている状態のうちに終了処理を始める
+		go func() { done <- s.Shutdown(ctx) }()
+		for !s.isClosing() {
+			time.Sleep(time.Millisecond)
+		}
+		s.closeIdle()
+	}}
+	go s.Serve(conn)
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(client)
+	for _, req := range []struct{ input, want string }{
+		{"1かんじ ", "1/漢字"},
+		{"1へんかん ", "1/変換"},
+	} {
+		if _, err := client.Write([]byte(req.input)); err != nil {
+			t.Fatalf("write %q: %v", req.input, err)
+		}
+		if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, req.want) {
+			t.Fatalf("response to %q = %q, %v", req.input, line, err)
+		}
+	}
+	// 応答した後は次のリクエストを待たずに閉じる
+	if _, err := r.ReadByte(); !isClosed(err) {
+		t.Errorf("connection is not closed after shutdown: %v", err)
+	}
+	client.Close()
+
+	if err := <-done; err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
+
+// TestShutdownIdle はリクエストを待っている接続が終了処理で閉じられることを確かめる
+func TestShutdownIdle(t *testing.T) {
+	s := newTestServer(t, nil)
+	client, server := net.Pipe()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.Serve(server)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(client)
+	if _, err := client.Write([]byte("1かんじ ")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+	<-served
+	if _, err := r.ReadByte(); !isClosed(err) {
+		t.Errorf("idle connection is not closed: %v", err)
+	}
+	client.Close()
+}
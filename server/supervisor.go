@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// 異常終了した処理を再実行するまでの最初の待ち時間。続けて異常終了するたびに倍にする
+	restartMinDelay = time.Second
+	restartMaxDelay = time.Minute
+	// この時間以上動いていた場合は待ち時間を最初に戻す
+	restartResetAfter = time.Minute
+)
+
+// 処理の状態
+const (
+	ComponentRunning    = "running"
+	ComponentRestarting = "restarting"
+	ComponentStopped    = "stopped"
+)
+
+// ComponentStatus は Supervisor が実行している処理の状態
+type ComponentStatus struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"last_error,omitempty"`
+	Since     time.Time `json:"since"`
+}
+
+// Supervisor は SKK サーバーや管理画面などの処理を実行し、異常終了した場合は待ち時間をおいて再実行する
+type Supervisor struct {
+	mu         sync.Mutex
+	components []*ComponentStatus
+	wg         sync.WaitGroup
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{}
+}
+
+func (sv *Supervisor) update(st *ComponentStatus, f func(st *ComponentStatus)) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	f(st)
+	st.Since = time.Now()
+}
+
+func (sv *Supervisor) remove(st *ComponentStatus) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	for i, c := range sv.components {
+		if c == st {
+			sv.components = append(sv.components[:i], sv.components[i+1:]...)
+			return
+		}
+	}
+}
+
+// Go は run を ctx が終了するまで実行する。run がエラーを返した場合は再実行し、nil を返した場合は停止したものとする。
+// ctx の終了で止めた処理は状態の一覧から取り除く。返したチャネルは処理が終わると閉じる
+func (sv *Supervisor) Go(ctx context.Context, name string, run func(ctx context.Context) error) <-chan struct{} {
+	st := &ComponentStatus{Name: name, State: ComponentRunning, Since: time.Now()}
+	sv.mu.Lock()
+	sv.components = append(sv.components, st)
+	sv.mu.Unlock()
+
+	done := make(chan struct{})
+	sv.wg.Add(1)
+	go func() {
+		defer sv.wg.Done()
+		defer close(done)
+
+		delay := restartMinDelay
+		for {
+			started := time.Now()
+			err := run(ctx)
+			if ctx.Err() != nil {
+				sv.remove(st)
+				return
+			}
+			if err == nil {
+				sv.update(st, func(st *ComponentStatus) { st.State = ComponentStopped })
+				return
+			}
+
+			if time.Since(started) >= restartResetAfter {
+				delay = restartMinDelay
+			}
+			slog.Error("component failed, restarting", "component", name, "delay", delay, "err", err)
+			sv.update(st, func(st *ComponentStatus) {
+				st.State = ComponentRestarting
+				st.LastError = err.Error()
+			})
+
+			select {
+			case <-ctx.Done():
+				sv.remove(st)
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, restartMaxDelay)
+			sv.update(st, func(st *ComponentStatus) {
+				st.State = ComponentRunning
+				st.Restarts++
+			})
+		}
+	}()
+	return done
+}
+
+// Status は実行している処理の状態を返す
+func (sv *Supervisor) Status() []ComponentStatus {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sts := make([]ComponentStatus, len(sv.components))
+	for i, c := range sv.components {
+		sts[i] = *c
+	}
+	return sts
+}
+
+// Wait は全ての処理が終わるまで待つ
+func (sv *Supervisor) Wait() {
+	sv.wg.Wait()
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// reloadSignal はシグナルでの再読み込みに対応していない環境では何も受け取らないチャネルを返す
+func reloadSignal() <-chan os.Signal {
+	return nil
+}
+
+// lockFile はファイルのロックに対応していない環境では何もしない
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// isLocked はファイルのロックに対応していない環境では常に実行中とみなす
+func isLocked(path string) (bool, error) {
+	return true, nil
+}
+
+func sendReload(pid int) error {
+	return fmt.Errorf("reload is not supported on this platform")
+}
@@ -0,0 +1,52 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// reloadSignal は設定の再読み込みを指示するシグナル(SIGHUP)を受け取るチャネルを返す
+func reloadSignal() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	return c
+}
+
+// lockFile は f を排他ロックする。他のプロセスがロックしている場合は解放されるまで待つ
+func lockFile(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if !errors.Is(err, syscall.EINTR) {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// isLocked は path が他のプロセスにロックされているかどうかを返す
+func isLocked(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return false, nil
+}
+
+// sendReload は pid のプロセスに設定の再読み込みを指示する
+func sendReload(pid int) error {
+	return errors.WithStack(syscall.Kill(pid, syscall.SIGHUP))
+}
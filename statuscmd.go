@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kan/bragi/config"
+	"github.com/kan/bragi/server"
+	"github.com/pkg/errors"
+)
+
+// printComponentStatus は実行中の Bragi の管理画面から処理の状態を取得して表示する。
+// 管理画面がクライアント証明書を要求する場合は certFile と keyFile の証明書を提示する。
+// 管理画面に接続できない場合はサービスの状態は表示済みのため、エラーを処理の状態として表示する
+func printComponentStatus(ctx context.Context, cpath, certFile, keyFile string) error {
+	conf, err := config.LoadConfig(cpath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sts, err := fetchComponentStatus(ctx, conf, certFile, keyFile)
+	if err != nil {
+		fmt.Printf("Components: unavailable (%v)\n", err)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tRESTARTS\tSINCE\tLAST ERROR")
+	for _, st := range sts {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", st.Name, st.State, st.Restarts, st.Since.Local().Format(time.DateTime), st.LastError)
+	}
+	return w.Flush()
+}
+
+// fetchComponentStatus は管理画面の API から処理の状態を取得する
+func fetchComponentStatus(ctx context.Context, conf *config.Config, certFile, keyFile string) ([]server.ComponentStatus, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	scheme := "http"
+	if conf.AdminTLS {
+		scheme = "https"
+		// 自己署名の証明書でも確認できるように設定の証明書を信頼する
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if pem, err := os.ReadFile(conf.TLSCert); err == nil {
+			pool.AppendCertsFromPEM(pem)
+		}
+		tc := &tls.Config{RootCAs: pool}
+
+		switch {
+		case certFile != "" || keyFile != "":
+			if certFile == "" || keyFile == "" {
+				return nil, fmt.Errorf("both --cert and --key are required")
+			}
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			tc.Certificates = []tls.Certificate{cert}
+		case conf.AdminTLSClientCA != "":
+			return nil, fmt.Errorf("admin server requires a client certificate, specify --cert and --key")
+		}
+		client.Transport = &http.Transport{TLSClientConfig: tc}
+	}
+
+	url := fmt.Sprintf("%s://localhost:%s/api/status", scheme, conf.AdminPort)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get status: %s", res.Status)
+	}
+
+	sts := []server.ComponentStatus{}
+	if err := json.NewDecoder(res.Body).Decode(&sts); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return sts, nil
+}
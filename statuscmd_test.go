@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kan/bragi/config"
+	"github.com/kan/bragi/server"
+)
+
+func TestFetchComponentStatusClientCert(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	clientCA := newTestCA(t, "client CA")
+
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, 1, x509.ExtKeyUsageServerAuth)
+	conf := &config.Config{
+		TLSCert:          filepath.Join(dir, "server.crt"),
+		TLSKey:           filepath.Join(dir, "server.key"),
+		AdminTLSClientCA: filepath.Join(dir, "client-ca.crt"),
+		AdminTLS:         true,
+	}
+	writeFile(t, conf.TLSCert, certPEM, time.Now())
+	writeFile(t, conf.TLSKey, keyPEM, time.Now())
+	writeFile(t, conf.AdminTLSClientCA, clientCA.pem, time.Now())
+
+	_, admin, err := newTLSConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, conf.AdminPort, _ = net.SplitHostPort(l.Addr().String())
+	hs := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode([]server.ComponentStatus{{Name: "skk", State: "running"}})
+		}),
+		TLSConfig: admin,
+	}
+	go hs.ServeTLS(l, "", "")
+	t.Cleanup(func() { hs.Close() })
+
+	ctx := context.Background()
+	if _, err := fetchComponentStatus(ctx, conf, "", ""); err == nil {
+		t.Error("fetched without client certificate")
+	}
+
+	clientCert, clientKey := clientCA.issue(t, 100, x509.ExtKeyUsageClientAuth)
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	writeFile(t, certFile, clientCert, time.Now())
+	writeFile(t, keyFile, clientKey, time.Now())
+	if _, err := fetchComponentStatus(ctx, conf, certFile, ""); err == nil {
+		t.Error("fetched with --cert only")
+	}
+	sts, err := fetchComponentStatus(ctx, conf, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sts) != 1 || sts[0].Name != "skk" {
+		t.Errorf("status = %+v", sts)
+	}
+}
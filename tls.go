@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kan/bragi/config"
+	"github.com/pkg/errors"
+)
+
+// 証明書ファイルの更新を確認する間隔
+const certCheckInterval = 5 * time.Second
+
+// certReloader は証明書ファイルを読み込み、ファイルが更新された場合は次の接続から新しい証明書を使う
+type certReloader struct {
+	certFile string
+	keyFile  string
+	// クライアント証明書を検証する CA の証明書。空の場合はクライアント証明書を要求しない
+	caFile string
+
+	mu      sync.Mutex
+	conf    *tls.Config
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	conf, modTime, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.conf, r.modTime, r.checked = conf, modTime, time.Now()
+	return r, nil
+}
+
+// lastModified は証明書ファイルの最終更新日時のうち最も新しいものを返す
+func (r *certReloader) lastModified() (time.Time, error) {
+	var t time.Time
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return t, errors.WithStack(err)
+		}
+		if fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t, nil
+}
+
+func (r *certReloader) load() (*tls.Config, time.Time, error) {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return nil, modTime, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, modTime, errors.WithStack(err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"http/1.1"},
+	}
+
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return nil, modTime, errors.WithStack(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, modTime, fmt.Errorf("no certificate found in %s", r.caFile)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return conf, modTime, nil
+}
+
+// current は現在の証明書の設定を返す。前回の確認から certCheckInterval 経過していればファイルの更新を確認する。
+// 読み込みに失敗した場合は以前の証明書を使い続ける
+func (r *certReloader) current() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) < certCheckInterval {
+		return r.conf
+	}
+	r.checked = time.Now()
+
+	if t, err := r.lastModified(); err != nil || !t.After(r.modTime) {
+		return r.conf
+	}
+	conf, modTime, err := r.load()
+	if err != nil {
+		slog.Error("failed to reload certificate, keep the previous certificate", "cert", r.certFile, "err", err)
+		return r.conf
+	}
+	slog.Info("Reload certificate", "cert", r.certFile)
+	r.conf, r.modTime = conf, modTime
+	return r.conf
+}
+
+// tlsConfig は接続ごとに現在の証明書を使う tls.Config を返す
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
+
+// newTLSConfig は設定に従って SKK サーバーと管理画面の TLS の設定を返す。TLS を使わない場合は nil を返す。
+// クライアント証明書を検証する CA は待ち受けごとに指定する
+func newTLSConfig(conf *config.Config) (skk *tls.Config, admin *tls.Config, err error) {
+	if !conf.SKKTLS && !conf.AdminTLS {
+		return nil, nil, nil
+	}
+	if conf.TLSCert == "" || conf.TLSKey == "" {
+		return nil, nil, fmt.Errorf("tls_cert and tls_key are required to use TLS")
+	}
+
+	if conf.SKKTLS {
+		r, err := newCertReloader(conf.TLSCert, conf.TLSKey, conf.SKKTLSClientCA)
+		if err != nil {
+			return nil, nil, err
+		}
+		skk = r.tlsConfig()
+	}
+	if conf.AdminTLS {
+		r, err := newCertReloader(conf.TLSCert, conf.TLSKey, conf.AdminTLSClientCA)
+		if err != nil {
+			return nil, nil, err
+		}
+		admin = r.tlsConfig()
+	}
+	return skk, admin, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kan/bragi/config"
+)
+
+// testCA はテスト用の証明書を発行する CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue は serial の証明書を発行し、証明書と秘密鍵を PEM で返す
+func (ca *testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func (ca *testCA) clientCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, 100, x509.ExtKeyUsageClientAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path string, b []byte, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS は tc で待ち受け、ハンドシェイクが終わった接続に1バイト返すサーバーを起動してアドレスを返す
+func serveTLS(t *testing.T, tc *tls.Config) string {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := conn.(*tls.Conn).Handshake(); err == nil {
+					conn.Write([]byte{1})
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// dialTLS は接続してサーバーの証明書のシリアル番号を返す
+func dialTLS(addr string, roots *x509.CertPool, certs []tls.Certificate) (int64, error) {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, Certificates: certs, ServerName: "localhost"})
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	// TLS 1.3 ではクライアント証明書の検証の失敗は最初の読み込みでわかる
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		return 0, err
+	}
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestCertReloaderRotation(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+
+	now := time.Now()
+	certPEM, keyPEM := ca.issue(t, 1, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM, now)
+	writeFile(t, keyFile, keyPEM, now)
+
+	r, err := newCertReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTLS(t, r.tlsConfig())
+	if serial, err := dialTLS(addr, ca.pool(), nil); err != nil || serial != 1 {
+		t.Fatalf("serial = %d, %v, want 1", serial, err)
+	}
+
+	certPEM, keyPEM = ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM, now.Add(time.Second))
+	writeFile(t, keyFile, keyPEM, now.Add(time.Second))
+
+	// certCheckInterval の間はファイルを確認しない
+	if serial, err := dialTLS(addr, ca.pool(), nil); err != nil || serial != 1 {
+		t.Fatalf("serial = %d, %v, want 1", serial, err)
+	}
+	r.mu.Lock()
+	r.checked = time.Time{}
+	r.mu.Unlock()
+	if serial, err := dialTLS(addr, ca.pool(), nil); err != nil || serial != 2 {
+		t.Fatalf("serial = %d, %v, want 2", serial, err)
+	}
+
+	// 壊れた証明書に置き換えた場合は前の証明書を使い続ける
+	writeFile(t, certFile, []byte("broken"), now.Add(2*time.Second))
+	r.mu.Lock()
+	r.checked = time.Time{}
+	r.mu.Unlock()
+	if serial, err := dialTLS(addr, ca.pool(), nil); err != nil || serial != 2 {
+		t.Fatalf("serial = %d, %v, want 2", serial, err)
+	}
+}
+
+func TestNewTLSConfigClientCA(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	clientCA := newTestCA(t, "client CA")
+	otherCA := newTestCA(t, "other CA")
+
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, 1, x509.ExtKeyUsageServerAuth)
+	conf := &config.Config{
+		TLSCert:        filepath.Join(dir, "server.crt"),
+		TLSKey:         filepath.Join(dir, "server.key"),
+		SKKTLSClientCA: filepath.Join(dir, "client-ca.crt"),
+		SKKTLS:         true,
+		AdminTLS:       true,
+	}
+	writeFile(t, conf.TLSCert, certPEM, time.Now())
+	writeFile(t, conf.TLSKey, keyPEM, time.Now())
+	writeFile(t, conf.SKKTLSClientCA, clientCA.pem, time.Now())
+
+	skk, admin, err := newTLSConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skkAddr, adminAddr := serveTLS(t, skk), serveTLS(t, admin)
+
+	// SKK サーバーは client CA が発行したクライアント証明書だけを受け付ける
+	if _, err := dialTLS(skkAddr, ca.pool(), nil); err == nil {
+		t.Error("skk: connected without client certificate")
+	}
+	if _, err := dialTLS(skkAddr, ca.pool(), []tls.Certificate{otherCA.clientCert(t)}); err == nil {
+		t.Error("skk: connected with client certificate issued by another CA")
+	}
+	if _, err := dialTLS(skkAddr, ca.pool(), []tls.Certificate{clientCA.clientCert(t)}); err != nil {
+		t.Errorf("skk: %v", err)
+	}
+
+	// 管理画面には CA を指定していないためクライアント証明書を要求しない
+	if _, err := dialTLS(adminAddr, ca.pool(), nil); err != nil {
+		t.Errorf("admin: %v", err)
+	}
+
+	conf.SKKTLSClientCA, conf.AdminTLSClientCA = "", conf.SKKTLSClientCA
+	skk, admin, err = newTLSConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skkAddr, adminAddr = serveTLS(t, skk), serveTLS(t, admin)
+	if _, err := dialTLS(skkAddr, ca.pool(), nil); err != nil {
+		t.Errorf("skk: %v", err)
+	}
+	if _, err := dialTLS(adminAddr, ca.pool(), nil); err == nil {
+		t.Error("admin: connected without client certificate")
+	}
+}